@@ -0,0 +1,249 @@
+package cv
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A Tracer which captures everything about a single frame's detection, to be
+// written out for inspecting failures offline: the input frame and every
+// traced image as PNGs, with a JSON manifest holding everything else.
+//
+// Typically set as Options.Tracer for one frame, then written out if the
+// result looks wrong.
+type Bundle struct {
+	mu sync.Mutex
+	frame image.Image
+	stages []bundleStage
+}
+
+type bundleStage struct {
+	name string
+	value interface{}
+}
+
+type bundleManifest struct {
+	Frame string `json:"frame"`
+	Width int `json:"width"`
+	Height int `json:"height"`
+	Stages []bundleManifestStage `json:"stages"`
+}
+
+type bundleManifestStage struct {
+	Name string `json:"name"`
+	// One of these will be set
+	Image string `json:"image,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+func NewBundle(frame image.Image) *Bundle {
+	return &Bundle{ frame: frame }
+}
+
+func (b *Bundle) Trace(name string, value interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.stages = append(b.stages, bundleStage{ name, value })
+}
+
+// Clears out the stages, ready for a new frame
+func (b *Bundle) Reset(frame image.Image) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.frame = frame
+	b.stages = nil
+}
+
+// Makes values friendlier to read in the manifest
+func manifestValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case []uint8:
+		// Otherwise they end up base64 encoded
+		ints := make([]int, len(t))
+		for i, x := range t {
+			ints[i] = int(x)
+		}
+		return ints
+	case fmt.Stringer:
+		return t.String()
+	}
+
+	// encoding/json refuses NaN and infinities, which would lose the whole
+	// manifest
+	if _, err := json.Marshal(v); err != nil {
+		return finiteValue(reflect.ValueOf(v))
+	}
+	return v
+}
+
+// Copies v into plain maps, slices and numbers, with any non-finite floats
+// replaced by "NaN", "+Inf" or "-Inf". Struct fields are named as
+// encoding/json would name them.
+func finiteValue(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return nil
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return strconv.FormatFloat(f, 'g', -1, 64)
+		}
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return finiteValue(v.Elem())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			out[i] = finiteValue(v.Index(i))
+		}
+		return out
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		out := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			out[fmt.Sprint(iter.Key().Interface())] = finiteValue(iter.Value())
+		}
+		return out
+	case reflect.Struct:
+		out := make(map[string]interface{}, v.NumField())
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+
+			name := f.Name
+			if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag == "-" {
+				continue
+			} else if tag != "" {
+				name = tag
+			}
+			out[name] = finiteValue(v.Field(i))
+		}
+		return out
+	}
+
+	return v.Interface()
+}
+
+// Calls write for each file in the bundle, in order, with the manifest last
+func (b *Bundle) walk(write func(name string, writeTo func(w io.Writer) error) error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	man := bundleManifest{
+		Stages: make([]bundleManifestStage, 0, len(b.stages)),
+	}
+
+	if b.frame != nil {
+		man.Frame = "frame.png"
+		man.Width, man.Height = ImageDims(b.frame)
+		err := write(man.Frame, func(w io.Writer) error {
+			return png.Encode(w, b.frame)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, s := range b.stages {
+		ms := bundleManifestStage{ Name: s.name }
+
+		if img, ok := s.value.(image.Image); ok {
+			ms.Image = fmt.Sprintf("%03d_%s.png", i, s.name)
+			err := write(ms.Image, func(w io.Writer) error {
+				return png.Encode(w, img)
+			})
+			if err != nil {
+				return err
+			}
+		} else {
+			ms.Value = manifestValue(s.value)
+		}
+
+		man.Stages = append(man.Stages, ms)
+	}
+
+	return write("manifest.json", func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(&man)
+	})
+}
+
+// Writes the bundle into dir, which is created if needed
+func (b *Bundle) WriteDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	return b.walk(func(name string, writeTo func(w io.Writer) error) error {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+
+		if err := writeTo(f); err != nil {
+			f.Close()
+			return err
+		}
+
+		return f.Close()
+	})
+}
+
+// Writes the bundle as a single zip file
+func (b *Bundle) WriteArchive(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := b.WriteZip(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// Writes the bundle to w as a zip archive
+func (b *Bundle) WriteZip(w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	err := b.walk(func(name string, writeTo func(w io.Writer) error) error {
+		fw, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		return writeTo(fw)
+	})
+	if err != nil {
+		zw.Close()
+		return err
+	}
+
+	return zw.Close()
+}
@@ -0,0 +1,201 @@
+package cv
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// Traces a real detection, plus some values encoding/json would choke on
+func tracedBundle() *Bundle {
+	img, _ := DefaultScene().Render()
+
+	b := NewBundle(img)
+	opts := DefaultOptions()
+	opts.Tracer = b
+
+	DetectBoard(img, DefaultPalette()[0].Color, img.Bounds(), opts)
+	FindHorizonLine(img, opts)
+
+	b.Trace("test.nan", math.NaN())
+	b.Trace("test.inf", []float64{ 1, math.Inf(1), math.Inf(-1) })
+	b.Trace("test.line", HorizonLine{ Slope: float32(math.Inf(1)), Intercept: 0.5 })
+
+	return b
+}
+
+// Checks the manifest and the files it names, given the bundle's files by
+// name
+func checkBundle(t *testing.T, b *Bundle, files map[string][]byte) {
+	t.Helper()
+
+	data, ok := files["manifest.json"]
+	if !ok {
+		t.Fatal("no manifest")
+	}
+	var man bundleManifest
+	if err := json.Unmarshal(data, &man); err != nil {
+		t.Fatalf("manifest: %v", err)
+	}
+
+	fw, fh := ImageDims(b.frame)
+	if man.Frame != "frame.png" || man.Width != fw || man.Height != fh {
+		t.Errorf("frame %q %dx%d, want frame.png %dx%d", man.Frame, man.Width, man.Height, fw, fh)
+	}
+
+	want := []string{ "manifest.json", man.Frame }
+	if len(man.Stages) != len(b.stages) {
+		t.Fatalf("%d stages in the manifest, %d traced", len(man.Stages), len(b.stages))
+	}
+	for i, ms := range man.Stages {
+		s := b.stages[i]
+		if ms.Name != s.name {
+			t.Errorf("stage %d is %q, want %q", i, ms.Name, s.name)
+		}
+
+		img, isImage := s.value.(image.Image)
+		if !isImage {
+			if ms.Image != "" {
+				t.Errorf("%s: not an image, but written as %q", ms.Name, ms.Image)
+			}
+			continue
+		}
+
+		want = append(want, ms.Image)
+		got, err := decodePNG(files[ms.Image])
+		if err != nil {
+			t.Errorf("%s: %s: %v", ms.Name, ms.Image, err)
+			continue
+		}
+		if got.Bounds().Size() != img.Bounds().Size() {
+			t.Errorf("%s: %v, traced %v", ms.Name, got.Bounds().Size(), img.Bounds().Size())
+		}
+	}
+
+	got := make([]string, 0, len(files))
+	for name := range files {
+		got = append(got, name)
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	if len(got) != len(want) {
+		t.Fatalf("files %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("files %v, want %v", got, want)
+		}
+	}
+
+	frame, err := decodePNG(files[man.Frame])
+	if err != nil {
+		t.Fatalf("frame: %v", err)
+	}
+	if frame.Bounds().Size() != b.frame.Bounds().Size() {
+		t.Errorf("frame is %v, want %v", frame.Bounds().Size(), b.frame.Bounds().Size())
+	}
+
+	// The values which can't be JSON numbers come back as strings
+	values := map[string]interface{}{}
+	for _, ms := range man.Stages {
+		values[ms.Name] = ms.Value
+	}
+	if v := values["test.nan"]; v != "NaN" {
+		t.Errorf("NaN written as %#v", v)
+	}
+	if v, _ := json.Marshal(values["test.inf"]); string(v) != `[1,"+Inf","-Inf"]` {
+		t.Errorf("infinities written as %s", v)
+	}
+	if v, _ := json.Marshal(values["test.line"]); string(v) != `{"Angle":0,"InlierRatio":0,"Intercept":0.5,"Slope":"+Inf"}` {
+		t.Errorf("line written as %s", v)
+	}
+	if v := values["board.state"]; v != BoardBothEdges.String() {
+		t.Errorf("board state written as %#v", v)
+	}
+}
+
+func decodePNG(data []byte) (image.Image, error) {
+	return png.Decode(bytes.NewReader(data))
+}
+
+func TestBundleWriteDir(t *testing.T) {
+	b := tracedBundle()
+	dir := filepath.Join(t.TempDir(), "bundle")
+	if err := b.WriteDir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{}
+	for _, e := range entries {
+		data, err := ioutil.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[e.Name()] = data
+	}
+
+	checkBundle(t, b, files)
+}
+
+func TestBundleWriteZip(t *testing.T) {
+	b := tracedBundle()
+	var buf bytes.Buffer
+	if err := b.WriteZip(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{}
+	for i, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = data
+
+		// The manifest goes last, so it can list everything before it
+		if (f.Name == "manifest.json") != (i == len(zr.File) - 1) {
+			t.Errorf("%s is file %d of %d", f.Name, i, len(zr.File))
+		}
+	}
+
+	checkBundle(t, b, files)
+}
+
+func TestBundleReset(t *testing.T) {
+	b := tracedBundle()
+	img, _ := DefaultScene().Render()
+	b.Reset(img)
+
+	var buf bytes.Buffer
+	if err := b.WriteZip(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 2 {
+		t.Errorf("%d files after Reset, want the frame and manifest", len(zr.File))
+	}
+}
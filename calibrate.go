@@ -0,0 +1,384 @@
+package cv
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+)
+
+// Zhang's method for the initial estimate, then Levenberg-Marquardt over the
+// intrinsics, K1, K2, P1, P2 and every view's pose to minimise the
+// reprojection error. K3 is left at zero: it's poorly constrained by the
+// handful of views people usually take, and small lenses don't need it.
+
+// Number of intrinsic parameters being refined: fx, fy, cx, cy, k1, k2, p1, p2
+const calibIntrinsics = 8
+
+type calibPose struct {
+	r, t vec3
+}
+
+// Homography taking model points to image points, by the normalised DLT
+func findHomography(model, img []Point2) mat3 {
+	normalise := func(pts []Point2) mat3 {
+		var cx, cy float64
+		for _, p := range pts {
+			cx += p.X
+			cy += p.Y
+		}
+		cx, cy = cx / float64(len(pts)), cy / float64(len(pts))
+
+		d := 0.0
+		for _, p := range pts {
+			d += math.Hypot(p.X - cx, p.Y - cy)
+		}
+		s := math.Sqrt2 / (d / float64(len(pts)))
+
+		return mat3{ { s, 0, -s * cx }, { 0, s, -s * cy }, { 0, 0, 1 } }
+	}
+
+	tm, ti := normalise(model), normalise(img)
+
+	a := newMatrix(2 * len(model), 9)
+	for i := range model {
+		m := tm.mulVec(vec3{ model[i].X, model[i].Y, 1 })
+		p := ti.mulVec(vec3{ img[i].X, img[i].Y, 1 })
+		x, y, u, v := m[0], m[1], p[0], p[1]
+
+		copy(a[2 * i], []float64{ -x, -y, -1, 0, 0, 0, u * x, u * y, u })
+		copy(a[2 * i + 1], []float64{ 0, 0, 0, -x, -y, -1, v * x, v * y, v })
+	}
+
+	h := smallestEigenvector(gram(a))
+	hn := mat3{ { h[0], h[1], h[2] }, { h[3], h[4], h[5] }, { h[6], h[7], h[8] } }
+
+	// Undo the normalisation. ti is just a scale and translation, so is
+	// easy to invert
+	s := ti[0][0]
+	tiInv := mat3{ { 1 / s, 0, -ti[0][2] / s }, { 0, 1 / s, -ti[1][2] / s }, { 0, 0, 1 } }
+
+	return tiInv.mul(hn).mul(tm)
+}
+
+// Closed-form intrinsics from three or more homographies, assuming zero skew
+func intrinsicsFromHomographies(hs []mat3) (fx, fy, cx, cy float64, ok bool) {
+	vij := func(h mat3, i, j int) []float64 {
+		return []float64{
+			h[0][i] * h[0][j],
+			h[0][i] * h[1][j] + h[1][i] * h[0][j],
+			h[1][i] * h[1][j],
+			h[2][i] * h[0][j] + h[0][i] * h[2][j],
+			h[2][i] * h[1][j] + h[1][i] * h[2][j],
+			h[2][i] * h[2][j],
+		}
+	}
+
+	rows := make([][]float64, 0, 2 * len(hs) + 1)
+	for _, h := range hs {
+		// Scale the homography so that all views count equally
+		n := h.col(0).norm()
+		for i := range h {
+			for j := range h[i] {
+				h[i][j] /= n
+			}
+		}
+
+		v11, v12, v22 := vij(h, 0, 0), vij(h, 0, 1), vij(h, 1, 1)
+		diff := make([]float64, 6)
+		for k := range diff {
+			diff[k] = v11[k] - v22[k]
+		}
+		rows = append(rows, v12, diff)
+	}
+	rows = append(rows, []float64{ 0, 1, 0, 0, 0, 0 })
+
+	b := smallestEigenvector(gram(rows))
+	if b[0] < 0 {
+		for i := range b {
+			b[i] = -b[i]
+		}
+	}
+	b11, b12, b22, b13, b23, b33 := b[0], b[1], b[2], b[3], b[4], b[5]
+
+	den := b11 * b22 - b12 * b12
+	if den <= 0 || b11 <= 0 {
+		return 0, 0, 0, 0, false
+	}
+
+	cy = (b12 * b13 - b11 * b23) / den
+	lambda := b33 - (b13 * b13 + cy * (b12 * b13 - b11 * b23)) / b11
+	if lambda <= 0 {
+		return 0, 0, 0, 0, false
+	}
+
+	fx = math.Sqrt(lambda / b11)
+	fy = math.Sqrt(lambda * b11 / den)
+	cx = -b13 * fx * fx / lambda
+
+	return fx, fy, cx, cy, true
+}
+
+// Pose of the board in a view, from its homography and the intrinsics
+func poseFromHomography(h mat3, fx, fy, cx, cy float64) calibPose {
+	kInv := mat3{ { 1 / fx, 0, -cx / fx }, { 0, 1 / fy, -cy / fy }, { 0, 0, 1 } }
+
+	r1 := kInv.mulVec(h.col(0))
+	r2 := kInv.mulVec(h.col(1))
+	t := kInv.mulVec(h.col(2))
+
+	s := 1 / r1.norm()
+	if t[2] < 0 {
+		// Board has to be in front of the camera
+		s = -s
+	}
+	r1, r2, t = r1.scale(s), r2.scale(s), t.scale(s)
+
+	// Noise means r1 and r2 won't be quite orthonormal
+	r1 = r1.scale(1 / r1.norm())
+	r2 = r2.sub(r1.scale(r1.dot(r2)))
+	r2 = r2.scale(1 / r2.norm())
+	r3 := r1.cross(r2)
+
+	rot := mat3{
+		{ r1[0], r2[0], r3[0] },
+		{ r1[1], r2[1], r3[1] },
+		{ r1[2], r2[2], r3[2] },
+	}
+
+	return calibPose{ r: rodriguesFromMatrix(rot), t: t }
+}
+
+func unpackIntrinsics(p []float64) (fx, fy, cx, cy float64, d Distortion) {
+	return p[0], p[1], p[2], p[3], Distortion{ K1: p[4], K2: p[5], P1: p[6], P2: p[7] }
+}
+
+// Writes the reprojection residuals of one view into res
+func viewResiduals(intr []float64, pose calibPose, model, img []Point2, res []float64) {
+	fx, fy, cx, cy, d := unpackIntrinsics(intr)
+	rot := rodrigues(pose.r)
+
+	for i, m := range model {
+		pc := rot.mulVec(vec3{ m.X, m.Y, 0 })
+		pc = vec3{ pc[0] + pose.t[0], pc[1] + pose.t[1], pc[2] + pose.t[2] }
+
+		xd, yd := d.Distort(pc[0] / pc[2], pc[1] / pc[2])
+
+		res[2 * i] = fx * xd + cx - img[i].X
+		res[2 * i + 1] = fy * yd + cy - img[i].Y
+	}
+}
+
+func packParams(intr []float64, poses []calibPose) []float64 {
+	p := append([]float64(nil), intr...)
+	for _, pose := range poses {
+		p = append(p, pose.r[:]...)
+		p = append(p, pose.t[:]...)
+	}
+	return p
+}
+
+func unpackParams(p []float64, views int) ([]float64, []calibPose) {
+	poses := make([]calibPose, views)
+	for v := range poses {
+		o := calibIntrinsics + 6 * v
+		copy(poses[v].r[:], p[o : o + 3])
+		copy(poses[v].t[:], p[o + 3 : o + 6])
+	}
+	return p[:calibIntrinsics], poses
+}
+
+func allResiduals(p []float64, model []Point2, views [][]Point2) []float64 {
+	intr, poses := unpackParams(p, len(views))
+	per := 2 * len(model)
+
+	res := make([]float64, per * len(views))
+	for v := range views {
+		viewResiduals(intr, poses[v], model, views[v], res[v * per : (v + 1) * per])
+	}
+	return res
+}
+
+func sumSquares(v []float64) float64 {
+	s := 0.0
+	for _, x := range v {
+		s += x * x
+	}
+	return s
+}
+
+// Levenberg-Marquardt with a forward-difference Jacobian. Each pose only
+// affects its own view, which keeps the Jacobian cheap to build.
+func refineCalibration(p []float64, model []Point2, views [][]Point2) []float64 {
+	nparams := len(p)
+	per := 2 * len(model)
+	nres := per * len(views)
+
+	res := allResiduals(p, model, views)
+	cost := sumSquares(res)
+	mu := 1e-3
+
+	jac := newMatrix(nres, nparams)
+	tmp := make([]float64, per)
+
+	for iter := 0; iter < 100; iter++ {
+		intr, poses := unpackParams(p, len(views))
+
+		for k := 0; k < nparams; k++ {
+			step := 1e-6 * math.Max(1, math.Abs(p[k]))
+			orig := p[k]
+			p[k] += step
+
+			if k < calibIntrinsics {
+				for v := range views {
+					viewResiduals(intr, poses[v], model, views[v], tmp)
+					for i := 0; i < per; i++ {
+						jac[v * per + i][k] = (tmp[i] - res[v * per + i]) / step
+					}
+				}
+			} else {
+				v := (k - calibIntrinsics) / 6
+				_, poses := unpackParams(p, len(views))
+				viewResiduals(intr, poses[v], model, views[v], tmp)
+				for i := 0; i < per; i++ {
+					jac[v * per + i][k] = (tmp[i] - res[v * per + i]) / step
+				}
+			}
+
+			p[k] = orig
+		}
+
+		jtj := gram(jac)
+		jtr := make([]float64, nparams)
+		for i, row := range jac {
+			for k, j := range row {
+				jtr[k] -= j * res[i]
+			}
+		}
+
+		improved := false
+		for tries := 0; tries < 10; tries++ {
+			a := newMatrix(nparams, nparams)
+			for i := range a {
+				copy(a[i], jtj[i])
+				a[i][i] += mu * math.Max(jtj[i][i], 1e-12)
+			}
+
+			delta, ok := solveLinear(a, jtr)
+			if !ok {
+				mu *= 10
+				continue
+			}
+
+			next := make([]float64, nparams)
+			for i := range next {
+				next[i] = p[i] + delta[i]
+			}
+
+			nextRes := allResiduals(next, model, views)
+			nextCost := sumSquares(nextRes)
+			if nextCost < cost {
+				converged := (cost - nextCost) < 1e-10 * cost
+				p, res, cost = next, nextRes, nextCost
+				mu = math.Max(mu / 10, 1e-12)
+				improved = true
+				if converged {
+					return p
+				}
+				break
+			}
+			mu *= 10
+		}
+
+		if !improved {
+			break
+		}
+	}
+
+	return p
+}
+
+// Estimates a camera's intrinsics and distortion from several views of a
+// chessboard with cols x rows inner corners, as found by
+// FindChessboardCorners. w and h are the resolution of the images. Also
+// returns the RMS reprojection error, in pixels.
+//
+// The mounting height and pitch aren't touched.
+func Calibrate(views [][]Point2, cols, rows int, w, h int) (*Camera, float64, error) {
+	if len(views) < 3 {
+		return nil, 0, fmt.Errorf("need at least 3 views, got %d", len(views))
+	}
+
+	// The size of the squares doesn't matter for the intrinsics
+	model := make([]Point2, 0, cols * rows)
+	for j := 0; j < rows; j++ {
+		for i := 0; i < cols; i++ {
+			model = append(model, Point2{ float64(i), float64(j) })
+		}
+	}
+
+	hs := make([]mat3, len(views))
+	for v, corners := range views {
+		if len(corners) != len(model) {
+			return nil, 0, fmt.Errorf("view %d has %d corners, expected %d", v, len(corners), len(model))
+		}
+		hs[v] = findHomography(model, corners)
+	}
+
+	fx, fy, cx, cy, ok := intrinsicsFromHomographies(append([]mat3(nil), hs...))
+	if !ok {
+		// Views too similar to solve for everything. Make a guess and
+		// hope the refinement sorts it out.
+		fx, fy, cx, cy = float64(w), float64(w), float64(w) / 2, float64(h) / 2
+	}
+
+	poses := make([]calibPose, len(views))
+	for v, h := range hs {
+		poses[v] = poseFromHomography(h, fx, fy, cx, cy)
+	}
+
+	p := packParams([]float64{ fx, fy, cx, cy, 0, 0, 0, 0 }, poses)
+	p = refineCalibration(p, model, views)
+
+	rms := math.Sqrt(sumSquares(allResiduals(p, model, views)) / float64(len(model) * len(views)))
+
+	fx, fy, cx, cy, d := unpackIntrinsics(p)
+	if math.IsNaN(rms) || fx <= 0 || fy <= 0 {
+		return nil, rms, fmt.Errorf("calibration diverged")
+	}
+
+	cam := &Camera{
+		Width: w,
+		Height: h,
+		FocalX: fx,
+		FocalY: fy,
+		CX: cx,
+		CY: cy,
+		Distortion: d,
+	}
+
+	return cam, rms, nil
+}
+
+func SaveCamera(path string, c *Camera) error {
+	data, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func LoadCamera(path string) (*Camera, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Camera{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,261 @@
+package cv
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// A wide-ish lens with enough of every kind of distortion to matter
+func testCalibCamera() *Camera {
+	return &Camera{
+		Width: 640,
+		Height: 480,
+		FocalX: 500,
+		FocalY: 510,
+		CX: 322,
+		CY: 236,
+		Distortion: Distortion{ K1: -0.2, K2: 0.05, P1: 0.002, P2: -0.001 },
+	}
+}
+
+// Board poses, turned various ways about its centre and about 12 squares
+// away, so that the board nearly fills a 640x480 frame
+var testCalibPoses = []vec3{
+	{ 0.3, 0, 0 },
+	{ 0, 0.35, 0 },
+	{ -0.25, 0.2, 0.1 },
+	{ 0.2, -0.3, -0.15 },
+	{ 0.1, 0.1, 0.4 },
+	{ -0.3, -0.2, 0 },
+}
+
+func testCalibModel(cols, rows int) []Point2 {
+	model := make([]Point2, 0, cols * rows)
+	for j := 0; j < rows; j++ {
+		for i := 0; i < cols; i++ {
+			model = append(model, Point2{ float64(i), float64(j) })
+		}
+	}
+	return model
+}
+
+// The pose of a board with cols x rows corners, rotated by r about its centre
+// and dist squares in front of the camera
+func testBoardPose(r vec3, cols, rows int, dist float64) calibPose {
+	rot := rodrigues(r)
+	centre := rot.mulVec(vec3{ float64(cols - 1) / 2, float64(rows - 1) / 2, 0 })
+	return calibPose{ r: r, t: vec3{ -centre[0], -centre[1], dist - centre[2] } }
+}
+
+// Where c puts each of the model points, on a board with the given pose
+func projectModel(c *Camera, pose calibPose, model []Point2) []Point2 {
+	intr := []float64{ c.FocalX, c.FocalY, c.CX, c.CY,
+		c.Distortion.K1, c.Distortion.K2, c.Distortion.P1, c.Distortion.P2 }
+
+	// The residuals against the origin are just the projections
+	res := make([]float64, 2 * len(model))
+	viewResiduals(intr, pose, model, make([]Point2, len(model)), res)
+
+	pts := make([]Point2, len(model))
+	for i := range pts {
+		pts[i] = Point2{ res[2 * i], res[2 * i + 1] }
+	}
+	return pts
+}
+
+func checkNearF(t *testing.T, what string, got, want, tol float64) {
+	t.Helper()
+	if math.Abs(got - want) > tol {
+		t.Errorf("%s: got %v, want %v (tolerance %v)", what, got, want, tol)
+	}
+}
+
+func checkCalibration(t *testing.T, got, want *Camera, ftol, ctol, dtol float64) {
+	t.Helper()
+	if got.Width != want.Width || got.Height != want.Height {
+		t.Errorf("resolution %dx%d, want %dx%d", got.Width, got.Height, want.Width, want.Height)
+	}
+	checkNearF(t, "FocalX", got.FocalX, want.FocalX, ftol)
+	checkNearF(t, "FocalY", got.FocalY, want.FocalY, ftol)
+	checkNearF(t, "CX", got.CX, want.CX, ctol)
+	checkNearF(t, "CY", got.CY, want.CY, ctol)
+	checkNearF(t, "K1", got.Distortion.K1, want.Distortion.K1, dtol)
+	checkNearF(t, "K2", got.Distortion.K2, want.Distortion.K2, 4 * dtol)
+	checkNearF(t, "P1", got.Distortion.P1, want.Distortion.P1, dtol / 4)
+	checkNearF(t, "P2", got.Distortion.P2, want.Distortion.P2, dtol / 4)
+	if got.Distortion.K3 != 0 {
+		t.Errorf("K3 %v, should be left at zero", got.Distortion.K3)
+	}
+}
+
+func TestFindHomography(t *testing.T) {
+	model := testCalibModel(9, 6)
+
+	// Without distortion the image of the board is exactly a homography
+	c := testCalibCamera()
+	c.Distortion = Distortion{}
+
+	for _, r := range testCalibPoses {
+		img := projectModel(c, testBoardPose(r, 9, 6, 12), model)
+		h := findHomography(model, img)
+
+		for i, m := range model {
+			p := h.mulVec(vec3{ m.X, m.Y, 1 })
+			x, y := p[0] / p[2], p[1] / p[2]
+			if math.Hypot(x - img[i].X, y - img[i].Y) > 1e-6 {
+				t.Errorf("pose %v, corner %v: maps to (%v, %v), want %v", r, m, x, y, img[i])
+			}
+		}
+	}
+}
+
+func TestPoseFromHomography(t *testing.T) {
+	model := testCalibModel(9, 6)
+	c := testCalibCamera()
+	c.Distortion = Distortion{}
+
+	for _, r := range testCalibPoses {
+		want := testBoardPose(r, 9, 6, 12)
+		h := findHomography(model, projectModel(c, want, model))
+		got := poseFromHomography(h, c.FocalX, c.FocalY, c.CX, c.CY)
+
+		for i := 0; i < 3; i++ {
+			checkNearF(t, "rotation", got.r[i], want.r[i], 1e-6)
+			checkNearF(t, "translation", got.t[i], want.t[i], 1e-6)
+		}
+	}
+}
+
+func TestCalibrateSynthetic(t *testing.T) {
+	want := testCalibCamera()
+	model := testCalibModel(9, 6)
+
+	views := make([][]Point2, len(testCalibPoses))
+	for v, r := range testCalibPoses {
+		views[v] = projectModel(want, testBoardPose(r, 9, 6, 12), model)
+	}
+
+	got, rms, err := Calibrate(views, 9, 6, want.Width, want.Height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rms > 1e-3 {
+		t.Errorf("RMS error %v for perfect corners", rms)
+	}
+	checkCalibration(t, got, want, 0.01, 0.01, 1e-5)
+}
+
+func TestCalibrateNoisy(t *testing.T) {
+	want := testCalibCamera()
+	model := testCalibModel(9, 6)
+	rnd := rand.New(rand.NewSource(1))
+
+	const noise = 0.2
+	views := make([][]Point2, len(testCalibPoses))
+	for v, r := range testCalibPoses {
+		views[v] = projectModel(want, testBoardPose(r, 9, 6, 12), model)
+		for i := range views[v] {
+			views[v][i].X += rnd.NormFloat64() * noise
+			views[v][i].Y += rnd.NormFloat64() * noise
+		}
+	}
+
+	got, rms, err := Calibrate(views, 9, 6, want.Width, want.Height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two coordinates per corner, less whatever the fit soaks up
+	if rms > 2 * noise {
+		t.Errorf("RMS error %v with %v pixels of noise", rms, noise)
+	}
+	checkCalibration(t, got, want, 5, 5, 0.02)
+}
+
+// The refinement on its own, starting from an undistorted guess which is a
+// few percent out
+func TestRefineCalibration(t *testing.T) {
+	want := testCalibCamera()
+	model := testCalibModel(9, 6)
+
+	views := make([][]Point2, len(testCalibPoses))
+	poses := make([]calibPose, len(testCalibPoses))
+	for v, r := range testCalibPoses {
+		pose := testBoardPose(r, 9, 6, 12)
+		views[v] = projectModel(want, pose, model)
+
+		poses[v] = pose
+		poses[v].r[0] += 0.02
+		poses[v].t[2] *= 1.05
+	}
+
+	start := packParams([]float64{ 480, 530, 310, 250, 0, 0, 0, 0 }, poses)
+	p := refineCalibration(start, model, views)
+
+	if cost := sumSquares(allResiduals(p, model, views)); cost > 1e-6 {
+		t.Errorf("residual %v after refinement", cost)
+	}
+
+	fx, fy, cx, cy, d := unpackIntrinsics(p)
+	got := &Camera{ Width: want.Width, Height: want.Height, FocalX: fx, FocalY: fy, CX: cx, CY: cy, Distortion: d }
+	checkCalibration(t, got, want, 0.01, 0.01, 1e-5)
+}
+
+func TestCalibrateBadViews(t *testing.T) {
+	c := testCalibCamera()
+	model := testCalibModel(9, 6)
+
+	views := make([][]Point2, 0, len(testCalibPoses))
+	for _, r := range testCalibPoses[:2] {
+		views = append(views, projectModel(c, testBoardPose(r, 9, 6, 12), model))
+	}
+	if _, _, err := Calibrate(views, 9, 6, c.Width, c.Height); err == nil {
+		t.Errorf("expected an error for 2 views")
+	}
+
+	views = append(views, projectModel(c, testBoardPose(testCalibPoses[2], 9, 6, 12), model)[1:])
+	if _, _, err := Calibrate(views, 9, 6, c.Width, c.Height); err == nil {
+		t.Errorf("expected an error for a view with a corner missing")
+	}
+}
+
+func TestRodrigues(t *testing.T) {
+	for _, r := range append(testCalibPoses, vec3{}, vec3{ 0, 0, 3.1 }, vec3{ 1.5, -2, 1 }) {
+		m := rodrigues(r)
+
+		// Orthonormal, and a rotation rather than a reflection
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 3; j++ {
+				want := 0.0
+				if i == j {
+					want = 1
+				}
+				checkNearF(t, "orthonormality", m.col(i).dot(m.col(j)), want, 1e-12)
+			}
+		}
+		checkNearF(t, "determinant", m.col(0).cross(m.col(1)).dot(m.col(2)), 1, 1e-12)
+
+		back := rodriguesFromMatrix(m)
+		for i := 0; i < 3; i++ {
+			checkNearF(t, "round trip", back[i], r[i], 1e-6)
+		}
+	}
+}
+
+func TestSolveLinear(t *testing.T) {
+	a := [][]float64{ { 0, 2, 1 }, { 1, -1, 0 }, { 3, 0, -2 } }
+	want := []float64{ 1, -2, 3 }
+	b := []float64{ -1, 3, -3 }
+
+	x, ok := solveLinear(a, b)
+	if !ok {
+		t.Fatal("singular")
+	}
+	for i := range want {
+		checkNearF(t, "x", x[i], want[i], 1e-12)
+	}
+
+	if _, ok := solveLinear([][]float64{ { 1, 2 }, { 2, 4 } }, []float64{ 1, 2 }); ok {
+		t.Errorf("expected a singular matrix to be rejected")
+	}
+}
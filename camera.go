@@ -0,0 +1,117 @@
+package cv
+
+import (
+	"math"
+)
+
+// A pinhole camera model for turning normalised image positions into angles
+// and distances. Angles are in radians, and distances are in whatever units
+// MountHeight (and any board widths) are given in.
+type Camera struct {
+	// Resolution, in pixels
+	Width int `json:"width"`
+	Height int `json:"height"`
+
+	// Focal length, in pixels
+	FocalX float64 `json:"focal_x"`
+	FocalY float64 `json:"focal_y"`
+
+	// Principal point, in pixels
+	CX float64 `json:"cx"`
+	CY float64 `json:"cy"`
+
+	Distortion Distortion `json:"distortion"`
+
+	// Height of the lens above the floor
+	MountHeight float64 `json:"mount_height"`
+	// Downwards tilt of the optical axis from horizontal
+	Pitch float64 `json:"pitch"`
+}
+
+// Returns a Camera with square pixels and a centred principal point, from its
+// horizontal field of view
+func NewCameraFOV(w, h int, hfov float64) *Camera {
+	f := (float64(w) / 2) / math.Tan(hfov / 2)
+
+	return &Camera{
+		Width: w,
+		Height: h,
+		FocalX: f,
+		FocalY: f,
+		CX: float64(w) / 2,
+		CY: float64(h) / 2,
+	}
+}
+
+// Horizontal and vertical field of view
+func (c *Camera) FOV() (h, v float64) {
+	return 2 * math.Atan(float64(c.Width) / (2 * c.FocalX)),
+		2 * math.Atan(float64(c.Height) / (2 * c.FocalY))
+}
+
+// Angle from the optical axis to normalised column x. Positive is to the right.
+func (c *Camera) Bearing(x float32) float64 {
+	px := float64(x) * float64(c.Width)
+	return math.Atan((px - c.CX) / c.FocalX)
+}
+
+// Angle below horizontal of normalised row y, taking Pitch into account.
+func (c *Camera) Depression(y float32) float64 {
+	py := float64(y) * float64(c.Height)
+	return c.Pitch + math.Atan((py - c.CY) / c.FocalY)
+}
+
+// Distance along the floor to the point seen at normalised row y, e.g. the
+// bottom of a board. +Inf if the row is at or above the horizon. If the
+// camera is pitched down far enough that the row looks back past vertical,
+// the point is behind the camera and the distance is negative.
+func (c *Camera) GroundDistance(y float32) float64 {
+	d := c.Depression(y)
+	if d <= 0 {
+		return math.Inf(1)
+	}
+
+	return c.MountHeight / math.Tan(d)
+}
+
+// Normalised row of the horizon, which may be outside [0, 1] if the camera is
+// pitched far enough.
+func (c *Camera) HorizonRow() float32 {
+	py := c.CY - c.FocalY * math.Tan(c.Pitch)
+	return float32(py / float64(c.Height))
+}
+
+// Distance to an object boardWidth wide, spanning normalised columns left to
+// right. Assumes the object is face-on to the camera.
+func (c *Camera) RangeFromWidth(left, right float32, boardWidth float64) float64 {
+	// Work in the image plane, so that off-centre objects aren't penalised
+	tl := (float64(left) * float64(c.Width) - c.CX) / c.FocalX
+	tr := (float64(right) * float64(c.Width) - c.CX) / c.FocalX
+	if tr <= tl {
+		return math.NaN()
+	}
+
+	return boardWidth / (tr - tl)
+}
+
+// Bearing to the middle of the board in det, and range to it using the
+// board's known width. Range is only meaningful with both edges in view,
+// otherwise it's estimated from the ground distance to the bottom, or NaN if
+// that isn't known either.
+func (c *Camera) BoardBearingRange(det BoardDetection, boardWidth float64) (bearing, rng float64) {
+	if det.State == BoardNotFound {
+		return math.NaN(), math.NaN()
+	}
+
+	bearing = c.Bearing((det.Left + det.Right) / 2)
+
+	if det.State == BoardBothEdges {
+		return bearing, c.RangeFromWidth(det.Left, det.Right, boardWidth)
+	}
+
+	if math.IsNaN(float64(det.Bottom)) {
+		return bearing, math.NaN()
+	}
+
+	return bearing, c.GroundDistance(det.Bottom)
+}
@@ -0,0 +1,123 @@
+package cv
+
+import (
+	"math"
+	"testing"
+)
+
+func near(a, b, tol float64) bool {
+	if math.IsInf(b, 0) || math.IsNaN(b) {
+		return math.IsInf(a, 0) == math.IsInf(b, 0) && math.IsNaN(a) == math.IsNaN(b) && (a > 0) == (b > 0)
+	}
+	return math.Abs(a - b) <= tol
+}
+
+// A 90 degree field of view on 640x480 gives a focal length of 320 pixels,
+// so the numbers are easy to work out by hand
+func testCamera(pitch float64) *Camera {
+	c := NewCameraFOV(640, 480, math.Pi / 2)
+	c.MountHeight = 0.1
+	c.Pitch = pitch
+	return c
+}
+
+func TestCameraBearing(t *testing.T) {
+	c := testCamera(0)
+	tests := []struct {
+		x float32
+		want float64
+	}{
+		{ 0.5, 0 },
+		{ 1, math.Pi / 4 },
+		{ 0, -math.Pi / 4 },
+		{ 0.75, math.Atan(0.5) },
+		{ 0.25, -math.Atan(0.5) },
+	}
+	for _, tc := range tests {
+		if got := c.Bearing(tc.x); !near(got, tc.want, 1e-6) {
+			t.Errorf("Bearing(%v) = %v, want %v", tc.x, got, tc.want)
+		}
+	}
+}
+
+func TestCameraGroundDistance(t *testing.T) {
+	tests := []struct {
+		name string
+		pitch float64
+		y float32
+		want float64
+	}{
+		// 120 pixels below the centre, 120 / 320 = 0.375
+		{ "level", 0, 0.75, 0.1 / 0.375 },
+		{ "bottom row", 0, 1, 0.1 / 0.75 },
+		{ "horizon", 0, 0.5, math.Inf(1) },
+		{ "sky", 0, 0.25, math.Inf(1) },
+		// Pitched down by 45 degrees, the centre row is as far away as the
+		// camera is high
+		{ "pitched", math.Pi / 4, 0.5, 0.1 },
+		{ "pitched sky", math.Pi / 4, 0, 0.1 / math.Tan(math.Pi / 4 - math.Atan(0.75)) },
+		{ "straight down", math.Pi / 2, 0.5, 0 },
+		// 0.2 rad past vertical, so the floor is seen behind the camera
+		{ "behind", math.Pi / 2 + 0.2, 0.5, -0.1 * math.Tan(0.2) },
+	}
+	for _, tc := range tests {
+		c := testCamera(tc.pitch)
+		if got := c.GroundDistance(tc.y); !near(got, tc.want, 1e-6) {
+			t.Errorf("%s: GroundDistance(%v) = %v, want %v", tc.name, tc.y, got, tc.want)
+		}
+	}
+
+	// Just below the horizon is a very long way away, at it is infinite
+	c := testCamera(0.2)
+	hz := c.HorizonRow()
+	if want := float32(240 - 320 * math.Tan(0.2)) / 480; math.Abs(float64(hz - want)) > 1e-6 {
+		t.Errorf("HorizonRow() = %v, want %v", hz, want)
+	}
+	if d := c.GroundDistance(hz - 0.01); !math.IsInf(d, 1) {
+		t.Errorf("above the horizon: %v", d)
+	}
+	if d := c.GroundDistance(hz + 0.001); d < 10 || math.IsInf(d, 0) {
+		t.Errorf("just below the horizon: %v", d)
+	}
+}
+
+func TestCameraRangeFromWidth(t *testing.T) {
+	c := testCamera(0)
+	tests := []struct {
+		left, right float32
+		want float64
+	}{
+		// Spans 320 pixels, as wide as the focal length
+		{ 0.25, 0.75, 0.5 },
+		{ 0.5, 0.75, 1 },
+		{ 0, 1, 0.25 },
+		{ 0.75, 0.25, math.NaN() },
+		{ 0.5, 0.5, math.NaN() },
+	}
+	for _, tc := range tests {
+		if got := c.RangeFromWidth(tc.left, tc.right, 0.5); !near(got, tc.want, 1e-6) {
+			t.Errorf("RangeFromWidth(%v, %v) = %v, want %v", tc.left, tc.right, got, tc.want)
+		}
+	}
+}
+
+func TestCameraBoardBearingRange(t *testing.T) {
+	c := testCamera(0)
+	nan := float32(math.NaN())
+	tests := []struct {
+		name string
+		det BoardDetection
+		bearing, rng float64
+	}{
+		{ "both edges", BoardDetection{ State: BoardBothEdges, Left: 0.25, Right: 0.75, Bottom: 0.75 }, 0, 0.5 },
+		{ "one edge", BoardDetection{ State: BoardLeftEdge, Left: 0.5, Right: 1, Bottom: 0.75 }, math.Atan(0.5), 0.1 / 0.375 },
+		{ "no bottom", BoardDetection{ State: BoardFillsView, Left: 0, Right: 1, Bottom: nan }, 0, math.NaN() },
+		{ "not found", BoardDetection{ State: BoardNotFound, Left: nan, Right: nan, Bottom: nan }, math.NaN(), math.NaN() },
+	}
+	for _, tc := range tests {
+		b, r := c.BoardBearingRange(tc.det, 0.5)
+		if !near(b, tc.bearing, 1e-6) || !near(r, tc.rng, 1e-6) {
+			t.Errorf("%s: bearing %v range %v, want %v and %v", tc.name, b, r, tc.bearing, tc.rng)
+		}
+	}
+}
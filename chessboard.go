@@ -0,0 +1,362 @@
+package cv
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+type Point2 struct {
+	X, Y float64
+}
+
+// Radius of the ring sampled around each pixel by the corner detector. Squares
+// need to be comfortably bigger than this.
+const chessRadius = 5
+
+var chessRing = func() [16]image.Point {
+	var ring [16]image.Point
+	for i := range ring {
+		a := float64(i) * 2 * math.Pi / 16
+		ring[i] = image.Pt(int(math.Round(chessRadius * math.Cos(a))), int(math.Round(chessRadius * math.Sin(a))))
+	}
+	return ring
+}()
+
+// ChESS (Bennett & Lasenby) corner response. Strongly positive at the X
+// junctions between chessboard squares, and not much anywhere else.
+func chessResponse(img *image.Gray) ([]int, int) {
+	w, h := ImageDims(img)
+	resp := make([]int, w * h)
+	peak := 0
+
+	offs := make([]int, len(chessRing))
+	for i, p := range chessRing {
+		offs[i] = p.Y * img.Stride + p.X
+	}
+
+	border := chessRadius + 1
+	for y := border; y < h - border; y++ {
+		for x := border; x < w - border; x++ {
+			base := img.PixOffset(x + img.Rect.Min.X, y + img.Rect.Min.Y)
+
+			var s [16]int
+			ringSum := 0
+			for i, o := range offs {
+				s[i] = int(img.Pix[base + o])
+				ringSum += s[i]
+			}
+
+			sum := 0
+			for n := 0; n < 4; n++ {
+				v := s[n] + s[n + 8] - s[n + 4] - s[n + 12]
+				if v < 0 {
+					v = -v
+				}
+				sum += v
+			}
+
+			diff := 0
+			for n := 0; n < 8; n++ {
+				v := s[n] - s[n + 8]
+				if v < 0 {
+					v = -v
+				}
+				diff += v
+			}
+
+			local := 0
+			for _, o := range []int{ 0, -1, 1, -img.Stride, img.Stride } {
+				local += int(img.Pix[base + o])
+			}
+			mean := ringSum - (local * 16) / 5
+			if mean < 0 {
+				mean = -mean
+			}
+
+			r := sum - diff - mean
+			resp[y * w + x] = r
+			if r > peak {
+				peak = r
+			}
+		}
+	}
+
+	return resp, peak
+}
+
+// Iteratively moves p to the point where the image gradients in its
+// neighbourhood are all orthogonal to the direction from it, i.e. the exact
+// corner
+func refineCorner(img *image.Gray, p Point2) Point2 {
+	const win = chessRadius
+	w, h := ImageDims(img)
+
+	at := func(x, y int) float64 {
+		x = max(0, min(w - 1, x))
+		y = max(0, min(h - 1, y))
+		return float64(img.Pix[img.PixOffset(x + img.Rect.Min.X, y + img.Rect.Min.Y)])
+	}
+
+	for iter := 0; iter < 10; iter++ {
+		var a [2][2]float64
+		var b [2]float64
+
+		cx, cy := int(math.Round(p.X)), int(math.Round(p.Y))
+		for y := cy - win; y <= cy + win; y++ {
+			for x := cx - win; x <= cx + win; x++ {
+				gx := (at(x + 1, y) - at(x - 1, y)) / 2
+				gy := (at(x, y + 1) - at(x, y - 1)) / 2
+
+				gxx, gxy, gyy := gx * gx, gx * gy, gy * gy
+				a[0][0] += gxx
+				a[0][1] += gxy
+				a[1][1] += gyy
+				b[0] += gxx * float64(x) + gxy * float64(y)
+				b[1] += gxy * float64(x) + gyy * float64(y)
+			}
+		}
+
+		det := a[0][0] * a[1][1] - a[0][1] * a[0][1]
+		if math.Abs(det) < 1e-9 {
+			break
+		}
+
+		np := Point2{
+			X: (a[1][1] * b[0] - a[0][1] * b[1]) / det,
+			Y: (a[0][0] * b[1] - a[0][1] * b[0]) / det,
+		}
+
+		// Don't let it wander off
+		if math.Abs(np.X - p.X) > win || math.Abs(np.Y - p.Y) > win {
+			break
+		}
+
+		moved := math.Hypot(np.X - p.X, np.Y - p.Y)
+		p = np
+		if moved < 0.01 {
+			break
+		}
+	}
+
+	return p
+}
+
+// Returns the strongest local maxima of the corner response, strongest first
+func findCornerCandidates(img *image.Gray, limit int) []Point2 {
+	w, h := ImageDims(img)
+	resp, peak := chessResponse(img)
+	if peak <= 0 {
+		return nil
+	}
+
+	type candidate struct {
+		x, y, r int
+	}
+	cands := []candidate{}
+
+	const nms = chessRadius - 1
+	threshold := peak / 5
+	for y := nms; y < h - nms; y++ {
+		for x := nms; x < w - nms; x++ {
+			r := resp[y * w + x]
+			if r <= threshold {
+				continue
+			}
+
+			isMax := true
+			for j := y - nms; j <= y + nms && isMax; j++ {
+				for i := x - nms; i <= x + nms; i++ {
+					o := resp[j * w + i]
+					// Break ties towards the top-left
+					if o > r || (o == r && (j < y || (j == y && i < x))) {
+						isMax = false
+						break
+					}
+				}
+			}
+			if isMax {
+				cands = append(cands, candidate{ x, y, r })
+			}
+		}
+	}
+
+	sort.Slice(cands, func(i, j int) bool { return cands[i].r > cands[j].r })
+	if len(cands) > limit {
+		cands = cands[:limit]
+	}
+
+	pts := make([]Point2, len(cands))
+	for i, c := range cands {
+		pts[i] = refineCorner(img, Point2{ float64(c.x), float64(c.y) })
+	}
+
+	return pts
+}
+
+// Links candidate corners into a grid by walking from the middle of the board
+// outwards, predicting where each neighbour should be from the spacing seen
+// so far. This copes with the perspective and lens distortion, and ignores
+// stray candidates off the board.
+func assembleGrid(pts []Point2, cols, rows int) ([]Point2, bool) {
+	n := cols * rows
+	if len(pts) < n {
+		return nil, false
+	}
+
+	// Start from whichever of the strongest candidates is most central
+	var cx, cy float64
+	for _, p := range pts[:n] {
+		cx += p.X
+		cy += p.Y
+	}
+	cx, cy = cx / float64(n), cy / float64(n)
+
+	seed := 0
+	for i, p := range pts {
+		if math.Hypot(p.X - cx, p.Y - cy) < math.Hypot(pts[seed].X - cx, pts[seed].Y - cy) {
+			seed = i
+		}
+	}
+
+	// Its nearest neighbours give the two grid directions
+	others := make([]int, 0, len(pts) - 1)
+	for i := range pts {
+		if i != seed {
+			others = append(others, i)
+		}
+	}
+	dist := func(i int) float64 {
+		return math.Hypot(pts[i].X - pts[seed].X, pts[i].Y - pts[seed].Y)
+	}
+	sort.Slice(others, func(a, b int) bool { return dist(others[a]) < dist(others[b]) })
+	if len(others) < 4 {
+		return nil, false
+	}
+
+	sub := func(a, b Point2) Point2 { return Point2{ a.X - b.X, a.Y - b.Y } }
+	u := sub(pts[others[0]], pts[seed])
+	var v Point2
+	bestCos := 1.0
+	for _, i := range others[1:4] {
+		c := sub(pts[i], pts[seed])
+		cos := math.Abs(u.X * c.X + u.Y * c.Y) / (math.Hypot(u.X, u.Y) * math.Hypot(c.X, c.Y))
+		if cos < bestCos {
+			bestCos, v = cos, c
+		}
+	}
+	if bestCos > 0.5 {
+		return nil, false
+	}
+
+	type node struct {
+		idx int
+		u, v Point2
+	}
+	grid := map[image.Point]node{ image.Pt(0, 0): { seed, u, v } }
+	used := map[int]bool{ seed: true }
+
+	queue := []image.Point{ image.Pt(0, 0) }
+	for len(queue) > 0 {
+		g := queue[0]
+		queue = queue[1:]
+		cur := grid[g]
+		p := pts[cur.idx]
+
+		for _, d := range []image.Point{ { 1, 0 }, { -1, 0 }, { 0, 1 }, { 0, -1 } } {
+			ng := g.Add(d)
+			if _, ok := grid[ng]; ok {
+				continue
+			}
+
+			step := Point2{
+				float64(d.X) * cur.u.X + float64(d.Y) * cur.v.X,
+				float64(d.X) * cur.u.Y + float64(d.Y) * cur.v.Y,
+			}
+			pred := Point2{ p.X + step.X, p.Y + step.Y }
+			tol := 0.3 * math.Hypot(step.X, step.Y)
+
+			best := -1
+			for i, q := range pts {
+				if used[i] {
+					continue
+				}
+				dist := math.Hypot(q.X - pred.X, q.Y - pred.Y)
+				if dist < tol {
+					tol, best = dist, i
+				}
+			}
+			if best < 0 {
+				continue
+			}
+
+			next := node{ best, cur.u, cur.v }
+			actual := sub(pts[best], p)
+			if d.X != 0 {
+				next.u = Point2{ actual.X * float64(d.X), actual.Y * float64(d.X) }
+			} else {
+				next.v = Point2{ actual.X * float64(d.Y), actual.Y * float64(d.Y) }
+			}
+
+			grid[ng] = next
+			used[best] = true
+			queue = append(queue, ng)
+		}
+	}
+
+	var bounds image.Rectangle
+	for g := range grid {
+		bounds = bounds.Union(image.Rect(g.X, g.Y, g.X + 1, g.Y + 1))
+	}
+
+	// Look for exactly one complete board's worth of corners, either way
+	// round
+	var ret []Point2
+	for _, transpose := range []bool{ false, true } {
+		if transpose && cols == rows {
+			break
+		}
+
+		gw, gh := cols, rows
+		if transpose {
+			gw, gh = rows, cols
+		}
+
+		for y0 := bounds.Min.Y; y0 + gh <= bounds.Max.Y; y0++ {
+			for x0 := bounds.Min.X; x0 + gw <= bounds.Max.X; x0++ {
+				found := make([]Point2, 0, n)
+				for j := 0; j < rows; j++ {
+					for i := 0; i < cols; i++ {
+						g := image.Pt(x0 + i, y0 + j)
+						if transpose {
+							g = image.Pt(x0 + j, y0 + i)
+						}
+						nd, ok := grid[g]
+						if !ok {
+							break
+						}
+						found = append(found, pts[nd.idx])
+					}
+				}
+
+				if len(found) == n {
+					if ret != nil {
+						// Ambiguous
+						return nil, false
+					}
+					ret = found
+				}
+			}
+		}
+	}
+
+	return ret, ret != nil
+}
+
+// Finds the inner corners of a chessboard with cols x rows of them, in
+// row-major order along the grid. Which corner is first depends on how the
+// board was lying. ok is false if the whole board couldn't be found.
+func FindChessboardCorners(img *image.Gray, cols, rows int) (corners []Point2, ok bool) {
+	pts := findCornerCandidates(img, 3 * cols * rows)
+	return assembleGrid(pts, cols, rows)
+}
@@ -0,0 +1,154 @@
+package cv
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+// Renders a board with cols x rows inner corners through c, with the given
+// pose, onto a mid-grey background. The board has a white border one square
+// wide. Each pixel is supersampled, so the edges are antialiased as a real
+// camera would see them. Pixel (x, y) covers x - 0.5 to x + 0.5, so its
+// centre is at the same coordinates as the corners.
+func renderChessboard(c *Camera, pose calibPose, cols, rows int) *image.Gray {
+	rot := rodrigues(pose.r)
+
+	// Board plane to normalised camera coordinates, and back
+	h := mat3{
+		{ rot[0][0], rot[0][1], pose.t[0] },
+		{ rot[1][0], rot[1][1], pose.t[1] },
+		{ rot[2][0], rot[2][1], pose.t[2] },
+	}
+	var hInv mat3
+	for i := 0; i < 3; i++ {
+		a := newMatrix(3, 3)
+		for j := range a {
+			copy(a[j], h[j][:])
+		}
+		e := []float64{ 0, 0, 0 }
+		e[i] = 1
+		col, _ := solveLinear(a, e)
+		for j := 0; j < 3; j++ {
+			hInv[j][i] = col[j]
+		}
+	}
+
+	const ss = 4
+	img := image.NewGray(image.Rect(0, 0, c.Width, c.Height))
+	for y := 0; y < c.Height; y++ {
+		for x := 0; x < c.Width; x++ {
+			total := 0
+			for sy := 0; sy < ss; sy++ {
+				for sx := 0; sx < ss; sx++ {
+					px := float64(x) - 0.5 + (float64(sx) + 0.5) / ss
+					py := float64(y) - 0.5 + (float64(sy) + 0.5) / ss
+					xn, yn := c.Distortion.Undistort((px - c.CX) / c.FocalX, (py - c.CY) / c.FocalY)
+
+					b := hInv.mulVec(vec3{ xn, yn, 1 })
+					bx, by := b[0] / b[2], b[1] / b[2]
+
+					switch {
+					case bx < -2 || bx > float64(cols + 1) || by < -2 || by > float64(rows + 1):
+						total += 128
+					case bx < -1 || bx > float64(cols) || by < -1 || by > float64(rows):
+						total += 230
+					case (int(math.Floor(bx)) + int(math.Floor(by))) & 1 == 0:
+						total += 230
+					default:
+						total += 20
+					}
+				}
+			}
+			img.Pix[img.PixOffset(x, y)] = uint8(total / (ss * ss))
+		}
+	}
+
+	return img
+}
+
+// The corners can come back starting from any corner of the board, and
+// either way round, so check that every one is near a true corner and that
+// neighbours in the result are neighbours on the board
+func checkChessboardCorners(t *testing.T, got, want []Point2, cols, rows int, tol float64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%d corners, want %d", len(got), len(want))
+	}
+
+	idx := make([]int, len(got))
+	seen := map[int]bool{}
+	for i, p := range got {
+		best := 0
+		for j, q := range want {
+			if math.Hypot(p.X - q.X, p.Y - q.Y) < math.Hypot(p.X - want[best].X, p.Y - want[best].Y) {
+				best = j
+			}
+		}
+		if d := math.Hypot(p.X - want[best].X, p.Y - want[best].Y); d > tol {
+			t.Errorf("corner %d at %v is %v from the nearest true corner %v", i, p, d, want[best])
+		}
+		if seen[best] {
+			t.Errorf("corner %d at %v is a duplicate", i, p)
+		}
+		seen[best] = true
+		idx[i] = best
+	}
+
+	adjacent := func(a, b int) bool {
+		ax, ay, bx, by := a % cols, a / cols, b % cols, b / cols
+		return absInt(ax - bx) + absInt(ay - by) == 1
+	}
+	for j := 0; j < rows; j++ {
+		for i := 0; i < cols; i++ {
+			n := j * cols + i
+			if i > 0 && !adjacent(idx[n - 1], idx[n]) {
+				t.Errorf("corners %d and %d aren't neighbours on the board", n - 1, n)
+			}
+			if j > 0 && !adjacent(idx[n - cols], idx[n]) {
+				t.Errorf("corners %d and %d aren't neighbours on the board", n - cols, n)
+			}
+		}
+	}
+}
+
+func TestFindChessboardCorners(t *testing.T) {
+	c := &Camera{
+		Width: 320,
+		Height: 240,
+		FocalX: 300,
+		FocalY: 300,
+		CX: 160,
+		CY: 120,
+		Distortion: Distortion{ K1: -0.1, K2: 0.01 },
+	}
+	cols, rows := 7, 5
+	model := testCalibModel(cols, rows)
+
+	for _, r := range []vec3{ {}, { 0.3, 0, 0 }, { 0, -0.35, 0.2 }, { 0.2, 0.2, 0.6 } } {
+		pose := testBoardPose(r, cols, rows, 11)
+		img := renderChessboard(c, pose, cols, rows)
+
+		got, ok := FindChessboardCorners(img, cols, rows)
+		if !ok {
+			t.Errorf("pose %v: board not found", r)
+			continue
+		}
+		checkChessboardCorners(t, got, projectModel(c, pose, model), cols, rows, 0.3)
+	}
+}
+
+// Asking for a bigger board than is there can't be satisfied
+func TestFindChessboardCornersWrongSize(t *testing.T) {
+	c := NewCameraFOV(320, 240, 1)
+	img := renderChessboard(c, testBoardPose(vec3{}, 7, 5, 11), 7, 5)
+
+	if _, ok := FindChessboardCorners(img, 8, 6); ok {
+		t.Errorf("found an 8x6 board in a 7x5 one")
+	}
+
+	blank := image.NewGray(image.Rect(0, 0, 320, 240))
+	if _, ok := FindChessboardCorners(blank, 7, 5); ok {
+		t.Errorf("found a board in a blank frame")
+	}
+}
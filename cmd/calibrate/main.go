@@ -0,0 +1,111 @@
+// Estimates camera intrinsics and lens distortion from a directory of
+// chessboard images, and writes them out as JSON for cv.LoadCamera.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/usedbytes/mini_mouse/cv"
+)
+
+func loadGray(path string) (*image.Gray, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return cv.ToGray(img), nil
+}
+
+func main() {
+	cols := flag.Int("cols", 9, "Inner corners along the chessboard's rows")
+	rows := flag.Int("rows", 6, "Inner corners along the chessboard's columns")
+	out := flag.String("o", "camera.json", "Output file")
+	height := flag.Float64("mount-height", 0, "Height of the camera above the floor, copied to the output")
+	pitch := flag.Float64("pitch", 0, "Downwards pitch of the camera in radians, copied to the output")
+	verbose := flag.Bool("v", false, "Report each image")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] DIR\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	dir := flag.Arg(0)
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	names := []string{}
+	for _, fi := range files {
+		switch strings.ToLower(filepath.Ext(fi.Name())) {
+		case ".jpg", ".jpeg", ".png":
+			names = append(names, fi.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var w, h int
+	views := [][]cv.Point2{}
+	for _, name := range names {
+		img, err := loadGray(filepath.Join(dir, name))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			continue
+		}
+
+		iw, ih := cv.ImageDims(img)
+		if w == 0 {
+			w, h = iw, ih
+		} else if iw != w || ih != h {
+			fmt.Fprintf(os.Stderr, "%s: size %dx%d doesn't match %dx%d, skipping\n", name, iw, ih, w, h)
+			continue
+		}
+
+		corners, ok := cv.FindChessboardCorners(img, *cols, *rows)
+		if *verbose {
+			fmt.Fprintf(os.Stderr, "%s: found=%v\n", name, ok)
+		}
+		if ok {
+			views = append(views, corners)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Found the board in %d of %d images\n", len(views), len(names))
+
+	cam, rms, err := cv.Calibrate(views, *cols, *rows, w, h)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	cam.MountHeight = *height
+	cam.Pitch = *pitch
+
+	fmt.Fprintf(os.Stderr, "RMS reprojection error: %.3f px\n", rms)
+
+	if err := cv.SaveCamera(*out, cam); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
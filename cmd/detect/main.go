@@ -0,0 +1,398 @@
+// Runs the detectors over an image, a directory of images or a recording, and
+// writes out the results for each frame.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/usedbytes/mini_mouse/cv"
+)
+
+type command struct {
+	name string
+	help string
+	// Returns the fields to output for one frame, and what to draw on the
+	// annotated frame
+	run func(img image.Image, opts *cv.Options) (record, []cv.Drawable)
+	// Adds any command-specific flags
+	flags func(fs *flag.FlagSet)
+	// Called once the flags are parsed
+	setup func() error
+}
+
+var commands = []*command{
+	boardCommand(),
+	horizonCommand(),
+	colorCommand(),
+}
+
+func boardCommand() *command {
+	var colorStr *string
+	var target color.Color
+
+	return &command{
+		name: "board",
+		help: "Find the board and its edges",
+		flags: func(fs *flag.FlagSet) {
+			colorStr = fs.String("color", "", "Board colour, a palette name or #rrggbb. Matched against the default palette if not set")
+		},
+		setup: func() (err error) {
+			if *colorStr != "" {
+				target, err = parseColor(*colorStr)
+			}
+			return err
+		},
+		run: func(img image.Image, opts *cv.Options) (record, []cv.Drawable) {
+			if target == nil {
+				class := cv.ClassifyBoard(img, cv.DefaultPalette(), opts)
+				r := append(record{ { "color", class.Name } }, boardFields(class.Board)...)
+				return r, []cv.Drawable{ class.Board }
+			}
+
+			det := cv.DetectBoard(img, target, image.Rectangle{}, opts)
+			return boardFields(det), []cv.Drawable{ det }
+		},
+	}
+}
+
+func boardFields(det cv.BoardDetection) record {
+	return record{
+		{ "state", det.State.String() },
+		{ "left", det.Left },
+		{ "right", det.Right },
+		{ "bottom", det.Bottom },
+		{ "confidence", det.Confidence },
+	}
+}
+
+func horizonCommand() *command {
+	return &command{
+		name: "horizon",
+		help: "Find the horizon, as a row and as a (possibly tilted) line",
+		run: func(img image.Image, opts *cv.Options) (record, []cv.Drawable) {
+			row := cv.FindHorizonOpts(img, opts)
+			line := cv.FindHorizonLine(img, opts)
+			r := record{
+				{ "row", row },
+				{ "slope", line.Slope },
+				{ "intercept", line.Intercept },
+				{ "angle", line.Angle },
+				{ "inlier_ratio", line.InlierRatio },
+			}
+			return r, []cv.Drawable{ line }
+		},
+	}
+}
+
+func colorCommand() *command {
+	return &command{
+		name: "colour",
+		help: "Find the board and match its colour against the default palette",
+		run: func(img image.Image, opts *cv.Options) (record, []cv.Drawable) {
+			class := cv.ClassifyBoard(img, cv.DefaultPalette(), opts)
+
+			dists := make([]int, len(class.Distances))
+			for i, d := range class.Distances {
+				dists[i] = int(d)
+			}
+
+			r := record{
+				{ "index", class.Index },
+				{ "name", class.Name },
+			}
+			r = append(r, boardFields(class.Board)...)
+			r = append(r, field{ "distances", dists })
+			return r, []cv.Drawable{ class }
+		},
+	}
+}
+
+func parseColor(s string) (color.Color, error) {
+	for _, c := range cv.DefaultPalette() {
+		if strings.EqualFold(c.Name, s) {
+			return c.Color, nil
+		}
+	}
+
+	if len(s) == 7 && s[0] == '#' {
+		v, err := strconv.ParseUint(s[1:], 16, 32)
+		if err == nil {
+			return color.NRGBA{ uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff }, nil
+		}
+	}
+
+	return nil, fmt.Errorf("bad colour %q, want a palette name or #rrggbb", s)
+}
+
+// Opens path as a FrameSource, depending on what it is
+func openSource(path string, size string, period time.Duration) (cv.FrameSource, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return cv.NewDirSource(path, period)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".y4m":
+		return cv.NewY4MSource(path)
+	case ".yuv", ".i420":
+		var w, h int
+		if _, err := fmt.Sscanf(size, "%dx%d", &w, &h); err != nil {
+			return nil, fmt.Errorf("raw I420 input needs -size WxH")
+		}
+		return cv.NewI420Source(path, w, h, period)
+	}
+
+	// A single image
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return cv.NewGeneratorSource(1, 0, func(seq int) image.Image { return img }), nil
+}
+
+// Options from -config, with any flags which were set on top
+type optionFlags struct {
+	config *string
+	edgeMode, lineMode *string
+	edgeLevel, lineLevel *uint
+	lineStripes *int
+	boardColor, paletteMatch *uint
+	metric *string
+}
+
+func addOptionFlags(fs *flag.FlagSet) *optionFlags {
+	def := cv.DefaultOptions()
+	return &optionFlags{
+		config: fs.String("config", "", "JSON file of detector options, as written by cv.SaveOptions"),
+		edgeMode: fs.String("edge-mode", def.EdgeThreshold.Mode.String(), "Edge image threshold mode: fixed, otsu, mean, gaussian or hysteresis"),
+		edgeLevel: fs.Uint("edge-level", uint(def.EdgeThreshold.Level), "Edge image threshold level"),
+		lineMode: fs.String("line-mode", def.LineThreshold.Mode.String(), "Line projection threshold mode"),
+		lineLevel: fs.Uint("line-level", uint(def.LineThreshold.Level), "Line projection threshold level"),
+		lineStripes: fs.Int("line-stripes", def.LineStripes, "Number of stripes in the line projections"),
+		boardColor: fs.Uint("board-color-threshold", uint(def.BoardColorThreshold), "Maximum DeltaC from the board colour"),
+		paletteMatch: fs.Uint("palette-match-threshold", uint(def.PaletteMatchThreshold), "Maximum DeltaC to the nearest palette colour"),
+		metric: fs.String("metric", def.Metric.Name(), "Colour difference metric: " + strings.Join(cv.ColorMetricNames(), ", ")),
+	}
+}
+
+func (of *optionFlags) options(fs *flag.FlagSet) (*cv.Options, error) {
+	opts := cv.DefaultOptions()
+	if *of.config != "" {
+		var err error
+		opts, err = cv.LoadOptions(*of.config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Stop at the first bad flag
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+
+		switch f.Name {
+		case "edge-mode":
+			err = opts.EdgeThreshold.Mode.UnmarshalText([]byte(*of.edgeMode))
+		case "line-mode":
+			err = opts.LineThreshold.Mode.UnmarshalText([]byte(*of.lineMode))
+		case "edge-level":
+			opts.EdgeThreshold.Level = uint8(*of.edgeLevel)
+		case "line-level":
+			opts.LineThreshold.Level = uint8(*of.lineLevel)
+		case "line-stripes":
+			opts.LineStripes = *of.lineStripes
+		case "board-color-threshold":
+			opts.BoardColorThreshold = uint8(*of.boardColor)
+		case "palette-match-threshold":
+			opts.PaletteMatchThreshold = uint8(*of.paletteMatch)
+		case "metric":
+			var m cv.ColorMetric
+			if m, err = cv.ColorMetricByName(*of.metric); err == nil {
+				opts.Metric = m
+			}
+		}
+		if err != nil {
+			err = fmt.Errorf("-%s: %v", f.Name, err)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return opts, opts.Validate()
+}
+
+func writeAnnotated(dir string, frame cv.Frame, ds []cv.Drawable) error {
+	b := frame.Image.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), frame.Image, b.Min, draw.Src)
+
+	o, err := cv.NewOverlay(rgba)
+	if err != nil {
+		return err
+	}
+	o.Draw(ds...)
+	o.Label(o.Scale, b.Dy() - 8 * o.Scale, fmt.Sprintf("%d", frame.Seq), cv.OverlayText)
+
+	f, err := os.Create(filepath.Join(dir, fmt.Sprintf("%06d.png", frame.Seq)))
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, rgba); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func run(cmd *command, args []string) error {
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	of := addOptionFlags(fs)
+	format := fs.String("format", "jsonl", "Output format: jsonl or csv")
+	out := fs.String("o", "", "Output file, default stdout")
+	annotate := fs.String("annotate", "", "Directory to write annotated frames to")
+	size := fs.String("size", "", "Frame size (WxH) for raw I420 input")
+	fps := fs.Float64("fps", 0, "Frame rate for directories and raw input. Directories use file times if not set")
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [flags] IMAGE|DIR|FILE.y4m|FILE.yuv\n\n%s\n\n", os.Args[0], cmd.name, cmd.help)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	opts, err := of.options(fs)
+	if err != nil {
+		return err
+	}
+
+	if cmd.setup != nil {
+		if err := cmd.setup(); err != nil {
+			return err
+		}
+	}
+
+	var period time.Duration
+	if *fps > 0 {
+		period = time.Duration(float64(time.Second) / *fps)
+	}
+
+	src, err := openSource(fs.Arg(0), *size, period)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	rw, err := newRecordWriter(w, *format)
+	if err != nil {
+		return err
+	}
+	defer rw.Flush()
+
+	if *annotate != "" {
+		if err := os.MkdirAll(*annotate, 0755); err != nil {
+			return err
+		}
+	}
+
+	for {
+		frame, err := src.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			// Just this frame is lost, the rest may be fine
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+
+		fields, ds := cmd.run(frame.Image, opts)
+
+		r := record{
+			{ "seq", frame.Seq },
+			{ "time_ms", float64(frame.Timestamp) / float64(time.Millisecond) },
+		}
+		if err := rw.Write(append(r, fields...)); err != nil {
+			return err
+		}
+
+		if *annotate != "" {
+			if err := writeAnnotated(*annotate, frame, ds); err != nil {
+				return err
+			}
+		}
+	}
+
+	return rw.Flush()
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s COMMAND [flags] INPUT\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.help)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s COMMAND -h' for a command's flags\n", os.Args[0])
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	name := os.Args[1]
+	if name == "color" {
+		name = "colour"
+	}
+
+	for _, c := range commands {
+		if c.name == name {
+			if err := run(c, os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	usage()
+	os.Exit(1)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+)
+
+type field struct {
+	name string
+	value interface{}
+}
+
+// One line of output, with the fields in the order they're written
+type record []field
+
+type recordWriter interface {
+	Write(r record) error
+	Flush() error
+}
+
+// NaN can't be represented in JSON, and is clearer as an empty CSV cell
+func isNaN(v interface{}) bool {
+	switch f := v.(type) {
+	case float32:
+		return math.IsNaN(float64(f))
+	case float64:
+		return math.IsNaN(f)
+	}
+	return false
+}
+
+type jsonWriter struct {
+	w *bufio.Writer
+}
+
+func (j *jsonWriter) Write(r record) error {
+	j.w.WriteByte('{')
+	for i, f := range r {
+		if i > 0 {
+			j.w.WriteByte(',')
+		}
+
+		k, _ := json.Marshal(f.name)
+		j.w.Write(k)
+		j.w.WriteByte(':')
+
+		if isNaN(f.value) {
+			j.w.WriteString("null")
+			continue
+		}
+		v, err := json.Marshal(f.value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.name, err)
+		}
+		j.w.Write(v)
+	}
+	j.w.WriteString("}\n")
+
+	return nil
+}
+
+func (j *jsonWriter) Flush() error {
+	return j.w.Flush()
+}
+
+type csvWriter struct {
+	w *csv.Writer
+	header bool
+}
+
+func (c *csvWriter) Write(r record) error {
+	if !c.header {
+		names := make([]string, len(r))
+		for i, f := range r {
+			names[i] = f.name
+		}
+		if err := c.w.Write(names); err != nil {
+			return err
+		}
+		c.header = true
+	}
+
+	cells := make([]string, len(r))
+	for i, f := range r {
+		switch v := f.value; {
+		case isNaN(v):
+			cells[i] = ""
+		case v == nil:
+			cells[i] = ""
+		default:
+			cells[i] = fmt.Sprint(v)
+		}
+	}
+
+	return c.w.Write(cells)
+}
+
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func newRecordWriter(w io.Writer, format string) (recordWriter, error) {
+	switch format {
+	case "jsonl", "json":
+		return &jsonWriter{ w: bufio.NewWriter(w) }, nil
+	case "csv":
+		return &csvWriter{ w: csv.NewWriter(w) }, nil
+	}
+	return nil, fmt.Errorf("unknown format %q, want jsonl or csv", format)
+}
@@ -0,0 +1,63 @@
+// Scores the detectors against a labelled dataset (see cv.LoadDataset).
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/usedbytes/mini_mouse/cv"
+)
+
+func main() {
+	config := flag.String("config", "", "JSON file of detector options, as written by cv.SaveOptions")
+	worst := flag.Int("worst", 10, "Number of worst frames to list")
+	asJSON := flag.Bool("json", false, "Write the report as JSON")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] DATASET.json\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if err := run(flag.Arg(0), *config, *worst, *asJSON); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(path, config string, worst int, asJSON bool) error {
+	opts := cv.DefaultOptions()
+	if config != "" {
+		var err error
+		opts, err = cv.LoadOptions(config)
+		if err != nil {
+			return err
+		}
+	}
+
+	ds, err := cv.LoadDataset(path)
+	if err != nil {
+		return err
+	}
+
+	frames, err := ds.Load()
+	if err != nil {
+		return err
+	}
+
+	report := cv.Evaluate(frames, cv.DefaultPalette(), opts, worst)
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		return enc.Encode(report)
+	}
+
+	return report.WriteText(os.Stdout)
+}
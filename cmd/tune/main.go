@@ -0,0 +1,191 @@
+// Searches for the detector options which score best against a labelled
+// dataset (see cv.LoadDataset), over the parameter ranges in a spec file (see
+// cv.TuneSpec).
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/usedbytes/mini_mouse/cv"
+)
+
+func writeCSV(w io.Writer, spec *cv.TuneSpec, results []cv.TuneResult) error {
+	cw := csv.NewWriter(w)
+
+	header := []string{}
+	for _, p := range spec.Params {
+		header = append(header, p.Name)
+	}
+	header = append(header, "score", "detection_rate", "false_positive_rate",
+		"left", "right", "bottom", "horizon", "horizon_line")
+	cw.Write(header)
+
+	for _, r := range results {
+		row := []string{}
+		for _, p := range spec.Params {
+			row = append(row, fmt.Sprint(r.Params[p.Name]))
+		}
+		rep := r.Report
+		for _, v := range []float64{ r.Score, rep.DetectionRate, rep.FalsePositiveRate,
+			rep.Left.Mean, rep.Right.Mean, rep.Bottom.Mean, rep.Horizon.Mean, rep.HorizonLine.Mean } {
+			row = append(row, fmt.Sprintf("%.5f", v))
+		}
+		cw.Write(row)
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+type bucket struct {
+	label string
+	// For sorting: the value, or the start of the bin
+	key float64
+	n int
+	sum, best float64
+}
+
+// The best and mean score for each value of p. Continuous ranges are split
+// into bins.
+func landscape(p cv.TuneParam, results []cv.TuneResult) []*bucket {
+	const bins = 10
+	continuous := len(p.Values) == 0 && p.Step <= 0 && p.Max > p.Min
+
+	buckets := map[string]*bucket{}
+	for _, r := range results {
+		v := r.Params[p.Name]
+
+		label := fmt.Sprint(v)
+		key := 0.0
+		if f, ok := v.(float64); ok {
+			key = f
+			if continuous {
+				bin := math.Min(bins - 1, math.Floor((f - p.Min) / (p.Max - p.Min) * bins))
+				key = p.Min + bin * (p.Max - p.Min) / bins
+				label = fmt.Sprintf("%.4g-%.4g", key, key + (p.Max - p.Min) / bins)
+			}
+		}
+
+		b, ok := buckets[label]
+		if !ok {
+			b = &bucket{ label: label, key: key, best: math.Inf(1) }
+			buckets[label] = b
+		}
+		b.n++
+		b.sum += r.Score
+		b.best = math.Min(b.best, r.Score)
+	}
+
+	list := make([]*bucket, 0, len(buckets))
+	for _, b := range buckets {
+		list = append(list, b)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].key != list[j].key {
+			return list[i].key < list[j].key
+		}
+		return list[i].label < list[j].label
+	})
+
+	return list
+}
+
+func run(path, specPath, config, out, report string, workers, top int) error {
+	base := cv.DefaultOptions()
+	if config != "" {
+		var err error
+		base, err = cv.LoadOptions(config)
+		if err != nil {
+			return err
+		}
+	}
+
+	spec, err := cv.LoadTuneSpec(specPath)
+	if err != nil {
+		return err
+	}
+
+	ds, err := cv.LoadDataset(path)
+	if err != nil {
+		return err
+	}
+	frames, err := ds.Load()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	results, err := cv.Tune(frames, cv.DefaultPalette(), base, spec, workers)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Evaluated %d configurations on %d frames in %v\n\n", len(results), len(frames), time.Since(start).Round(time.Millisecond))
+
+	baseReport := cv.Evaluate(frames, cv.DefaultPalette(), base, 0)
+	fmt.Printf("Base score: %.5f\n", cv.EvalScore(baseReport))
+
+	fmt.Println("Best:")
+	for i := 0; i < top && i < len(results); i++ {
+		fmt.Printf("  %.5f %v\n", results[i].Score, results[i].Params)
+	}
+
+	fmt.Println("\nScore by parameter (best / mean):")
+	for _, p := range spec.Params {
+		fmt.Printf("  %s\n", p.Name)
+		for _, b := range landscape(p, results) {
+			fmt.Printf("    %-16s %.5f / %.5f (%d)\n", b.label, b.best, b.sum / float64(b.n), b.n)
+		}
+	}
+
+	if report != "" {
+		f, err := os.Create(report)
+		if err != nil {
+			return err
+		}
+		if err := writeCSV(f, spec, results); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+
+	if len(results) > 0 && out != "" {
+		fmt.Printf("\nWriting best options to %s\n", out)
+		return cv.SaveOptions(out, results[0].Options)
+	}
+
+	return nil
+}
+
+func main() {
+	specPath := flag.String("spec", "tune.json", "Parameter ranges to search")
+	config := flag.String("config", "", "Base detector options, default cv.DefaultOptions")
+	out := flag.String("o", "best.json", "Where to write the best options")
+	report := flag.String("report", "", "CSV file to write every configuration's scores to")
+	workers := flag.Int("workers", 0, "Number of parallel evaluations, default one per CPU")
+	top := flag.Int("top", 10, "Number of best configurations to print")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] DATASET.json\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if err := run(flag.Arg(0), *specPath, *config, *out, *report, *workers, *top); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
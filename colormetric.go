@@ -0,0 +1,378 @@
+package cv
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// A way of measuring how different two colours are
+type ColorMetric interface {
+	// Used to select the metric in Options files
+	Name() string
+	// From 0 (identical) up to 255
+	Delta(a, b color.Color) uint8
+	// The same, for the fast paths on YCbCr images
+	DeltaYCbCr(a, b color.YCbCr) uint8
+}
+
+// Optionally implemented by metrics to speed up the fast paths on RGB and
+// grey images. a and b are always opaque.
+type RGBColorMetric interface {
+	ColorMetric
+	DeltaRGB(a, b color.NRGBA) uint8
+}
+
+var (
+	// What DeltaC has always done: Euclidean distance in YCbCr if both
+	// colours are color.YCbCr, otherwise DeltaCNRGBA's weighted RGB
+	// distance. Note that these don't agree with each other. The YCbCr fast
+	// paths take the square root of the integer sum of squares, so can be 1
+	// higher than DeltaC.
+	DefaultMetric ColorMetric = defaultMetric{}
+	// Euclidean distance over Y, Cb and Cr, for any colour
+	YCbCrMetric ColorMetric = ycbcrMetric{}
+	// DeltaCNRGBA's weighted RGB distance, for any colour
+	RGBMetric ColorMetric = rgbMetric{}
+	// CIE 1976 delta E in L*a*b*, scaled so that 255 is a delta E of 100
+	CIE76Metric ColorMetric = cie76Metric{}
+	// CIEDE2000 delta E in L*a*b*, scaled as CIE76Metric
+	CIEDE2000Metric ColorMetric = ciede2000Metric{}
+	// Euclidean distance over Cb and Cr only, ignoring luma, so is the
+	// least affected by lighting
+	ChromaMetric ColorMetric = chromaMetric{}
+)
+
+var colorMetrics = map[string]ColorMetric{}
+
+func init() {
+	for _, m := range []ColorMetric{
+		DefaultMetric, YCbCrMetric, RGBMetric,
+		CIE76Metric, CIEDE2000Metric, ChromaMetric,
+	} {
+		RegisterColorMetric(m)
+	}
+}
+
+// Makes m available by name to LoadOptions
+func RegisterColorMetric(m ColorMetric) {
+	colorMetrics[m.Name()] = m
+}
+
+func ColorMetricByName(name string) (ColorMetric, error) {
+	m, ok := colorMetrics[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown colour metric %q", name)
+	}
+	return m, nil
+}
+
+// Names of all the registered metrics, sorted
+func ColorMetricNames() []string {
+	names := make([]string, 0, len(colorMetrics))
+	for name := range colorMetrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func metricOrDefault(m ColorMetric) ColorMetric {
+	if m == nil {
+		return DefaultMetric
+	}
+	return m
+}
+
+type rgbAdapter struct {
+	ColorMetric
+}
+
+func (m rgbAdapter) DeltaRGB(a, b color.NRGBA) uint8 {
+	return m.Delta(a, b)
+}
+
+func rgbMetricOf(m ColorMetric) RGBColorMetric {
+	if rm, ok := m.(RGBColorMetric); ok {
+		return rm
+	}
+	return rgbAdapter{ m }
+}
+
+func clampDelta(d float64) uint8 {
+	if d >= 255 {
+		return 255
+	}
+	return uint8(d)
+}
+
+func asYCbCr(c color.Color) color.YCbCr {
+	return color.YCbCrModel.Convert(c).(color.YCbCr)
+}
+
+func asNRGBA(c color.Color) color.NRGBA {
+	return color.NRGBAModel.Convert(c).(color.NRGBA)
+}
+
+// Only for opaque colours, for which it's the same as asYCbCr
+func rgbToYCbCr(c color.NRGBA) color.YCbCr {
+	y, cb, cr := color.RGBToYCbCr(c.R, c.G, c.B)
+	return color.YCbCr{ Y: y, Cb: cb, Cr: cr }
+}
+
+type defaultMetric struct{}
+
+func (defaultMetric) Name() string {
+	return "default"
+}
+
+func (defaultMetric) Delta(a, b color.Color) uint8 {
+	return DeltaC(a, b)
+}
+
+func (defaultMetric) DeltaYCbCr(a, b color.YCbCr) uint8 {
+	dY := absdiff_uint8(a.Y, b.Y)
+	dCb := absdiff_uint8(a.Cb, b.Cb)
+	dCr := absdiff_uint8(a.Cr, b.Cr)
+	return uint8(math.Sqrt(float64(dY * dY + dCb * dCb + dCr * dCr)))
+}
+
+func (defaultMetric) DeltaRGB(a, b color.NRGBA) uint8 {
+	return DeltaCNRGBA(a, b)
+}
+
+type ycbcrMetric struct{}
+
+func (ycbcrMetric) Name() string {
+	return "ycbcr"
+}
+
+func (m ycbcrMetric) Delta(a, b color.Color) uint8 {
+	return m.DeltaYCbCr(asYCbCr(a), asYCbCr(b))
+}
+
+func (ycbcrMetric) DeltaYCbCr(a, b color.YCbCr) uint8 {
+	return clampDelta(deltaYCbCr(a, b))
+}
+
+func (m ycbcrMetric) DeltaRGB(a, b color.NRGBA) uint8 {
+	return m.DeltaYCbCr(rgbToYCbCr(a), rgbToYCbCr(b))
+}
+
+type rgbMetric struct{}
+
+func (rgbMetric) Name() string {
+	return "rgb"
+}
+
+func (rgbMetric) Delta(a, b color.Color) uint8 {
+	return clampDelta(deltaNRGBA(asNRGBA(a), asNRGBA(b)))
+}
+
+func (m rgbMetric) DeltaYCbCr(a, b color.YCbCr) uint8 {
+	return m.Delta(a, b)
+}
+
+func (rgbMetric) DeltaRGB(a, b color.NRGBA) uint8 {
+	return clampDelta(deltaNRGBA(a, b))
+}
+
+type chromaMetric struct{}
+
+func (chromaMetric) Name() string {
+	return "chroma"
+}
+
+func (m chromaMetric) Delta(a, b color.Color) uint8 {
+	return m.DeltaYCbCr(asYCbCr(a), asYCbCr(b))
+}
+
+func (chromaMetric) DeltaYCbCr(a, b color.YCbCr) uint8 {
+	cb := float64(absdiff_uint8(a.Cb, b.Cb))
+	cr := float64(absdiff_uint8(a.Cr, b.Cr))
+	return clampDelta(math.Sqrt(cb * cb + cr * cr))
+}
+
+func (m chromaMetric) DeltaRGB(a, b color.NRGBA) uint8 {
+	return m.DeltaYCbCr(rgbToYCbCr(a), rgbToYCbCr(b))
+}
+
+// Delta E scaled to 0-255
+func scaleDeltaE(de float64) uint8 {
+	return clampDelta(de * 2.55)
+}
+
+type cie76Metric struct{}
+
+func (cie76Metric) Name() string {
+	return "cie76"
+}
+
+func (cie76Metric) Delta(a, b color.Color) uint8 {
+	return scaleDeltaE(labFromColor(a).cie76(labFromColor(b)))
+}
+
+func (cie76Metric) DeltaYCbCr(a, b color.YCbCr) uint8 {
+	return scaleDeltaE(labFromYCbCr(a).cie76(labFromYCbCr(b)))
+}
+
+func (cie76Metric) DeltaRGB(a, b color.NRGBA) uint8 {
+	return scaleDeltaE(labFromRGB(a.R, a.G, a.B).cie76(labFromRGB(b.R, b.G, b.B)))
+}
+
+type ciede2000Metric struct{}
+
+func (ciede2000Metric) Name() string {
+	return "ciede2000"
+}
+
+func (ciede2000Metric) Delta(a, b color.Color) uint8 {
+	return scaleDeltaE(labFromColor(a).ciede2000(labFromColor(b)))
+}
+
+func (ciede2000Metric) DeltaYCbCr(a, b color.YCbCr) uint8 {
+	return scaleDeltaE(labFromYCbCr(a).ciede2000(labFromYCbCr(b)))
+}
+
+func (ciede2000Metric) DeltaRGB(a, b color.NRGBA) uint8 {
+	return scaleDeltaE(labFromRGB(a.R, a.G, a.B).ciede2000(labFromRGB(b.R, b.G, b.B)))
+}
+
+// CIE L*a*b*, D65 white
+type lab struct {
+	L, A, B float64
+}
+
+// sRGB gamma expansion for each 8-bit level
+var srgbLinear [256]float64
+
+func init() {
+	for i := range srgbLinear {
+		c := float64(i) / 255
+		if c <= 0.04045 {
+			srgbLinear[i] = c / 12.92
+		} else {
+			srgbLinear[i] = math.Pow((c + 0.055) / 1.055, 2.4)
+		}
+	}
+}
+
+func labF(t float64) float64 {
+	const delta = 6.0 / 29.0
+	if t > delta * delta * delta {
+		return math.Cbrt(t)
+	}
+	return t / (3 * delta * delta) + 4.0 / 29.0
+}
+
+func labFromRGB(r8, g8, b8 uint8) lab {
+	r, g, b := srgbLinear[r8], srgbLinear[g8], srgbLinear[b8]
+
+	x := (0.4124564 * r + 0.3575761 * g + 0.1804375 * b) / 0.95047
+	y := 0.2126729 * r + 0.7151522 * g + 0.0721750 * b
+	z := (0.0193339 * r + 0.1191920 * g + 0.9503041 * b) / 1.08883
+
+	fx, fy, fz := labF(x), labF(y), labF(z)
+	return lab{
+		L: 116 * fy - 16,
+		A: 500 * (fx - fy),
+		B: 200 * (fy - fz),
+	}
+}
+
+func labFromYCbCr(c color.YCbCr) lab {
+	return labFromRGB(color.YCbCrToRGB(c.Y, c.Cb, c.Cr))
+}
+
+func labFromColor(c color.Color) lab {
+	if v, ok := c.(color.YCbCr); ok {
+		return labFromYCbCr(v)
+	}
+	n := asNRGBA(c)
+	return labFromRGB(n.R, n.G, n.B)
+}
+
+func (p lab) cie76(q lab) float64 {
+	dl, da, db := p.L - q.L, p.A - q.A, p.B - q.B
+	return math.Sqrt(dl * dl + da * da + db * db)
+}
+
+func deg2rad(d float64) float64 {
+	return d * math.Pi / 180
+}
+
+// Hue angle in degrees, [0, 360)
+func hueAngle(b, a float64) float64 {
+	if a == 0 && b == 0 {
+		return 0
+	}
+	h := math.Atan2(b, a) * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+	return h
+}
+
+// Following Sharma, Wu and Dalal, "The CIEDE2000 Color-Difference Formula:
+// Implementation Notes, Supplementary Test Data, and Mathematical
+// Observations" (2005), with kL = kC = kH = 1
+func (p lab) ciede2000(q lab) float64 {
+	pow25to7 := math.Pow(25, 7)
+
+	c1 := math.Hypot(p.A, p.B)
+	c2 := math.Hypot(q.A, q.B)
+	cbar7 := math.Pow((c1 + c2) / 2, 7)
+	g := 0.5 * (1 - math.Sqrt(cbar7 / (cbar7 + pow25to7)))
+
+	a1p, a2p := (1 + g) * p.A, (1 + g) * q.A
+	c1p, c2p := math.Hypot(a1p, p.B), math.Hypot(a2p, q.B)
+	h1p, h2p := hueAngle(p.B, a1p), hueAngle(q.B, a2p)
+
+	dLp := q.L - p.L
+	dCp := c2p - c1p
+
+	dhp := 0.0
+	if c1p * c2p != 0 {
+		dhp = h2p - h1p
+		if dhp > 180 {
+			dhp -= 360
+		} else if dhp < -180 {
+			dhp += 360
+		}
+	}
+	dHp := 2 * math.Sqrt(c1p * c2p) * math.Sin(deg2rad(dhp / 2))
+
+	lbarp := (p.L + q.L) / 2
+	cbarp := (c1p + c2p) / 2
+
+	hbarp := h1p + h2p
+	if c1p * c2p != 0 {
+		switch {
+		case math.Abs(h1p - h2p) <= 180:
+			hbarp = (h1p + h2p) / 2
+		case h1p + h2p < 360:
+			hbarp = (h1p + h2p + 360) / 2
+		default:
+			hbarp = (h1p + h2p - 360) / 2
+		}
+	}
+
+	t := 1 - 0.17 * math.Cos(deg2rad(hbarp - 30)) +
+		0.24 * math.Cos(deg2rad(2 * hbarp)) +
+		0.32 * math.Cos(deg2rad(3 * hbarp + 6)) -
+		0.20 * math.Cos(deg2rad(4 * hbarp - 63))
+
+	dTheta := 30 * math.Exp(-math.Pow((hbarp - 275) / 25, 2))
+	cbarp7 := math.Pow(cbarp, 7)
+	rc := 2 * math.Sqrt(cbarp7 / (cbarp7 + pow25to7))
+
+	l50 := (lbarp - 50) * (lbarp - 50)
+	sl := 1 + 0.015 * l50 / math.Sqrt(20 + l50)
+	sc := 1 + 0.045 * cbarp
+	sh := 1 + 0.015 * cbarp * t
+	rt := -math.Sin(deg2rad(2 * dTheta)) * rc
+
+	dl, dc, dh := dLp / sl, dCp / sc, dHp / sh
+	return math.Sqrt(dl * dl + dc * dc + dh * dh + rt * dc * dh)
+}
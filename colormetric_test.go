@@ -0,0 +1,97 @@
+package cv
+
+import (
+	"image/color"
+	"math"
+	"testing"
+)
+
+// The test data from Sharma, Wu and Dalal, "The CIEDE2000 Color-Difference
+// Formula: Implementation Notes, Supplementary Test Data, and Mathematical
+// Observations", Color Research & Application 30(1), 2005. It covers the
+// hue wrap-around, the mean hue either side of 180 degrees, and achromatic
+// colours, which are easy to get wrong.
+var ciede2000Pairs = []struct {
+	p, q lab
+	de float64
+}{
+	{ lab{ 50, 2.6772, -79.7751 }, lab{ 50, 0, -82.7485 }, 2.0425 },
+	{ lab{ 50, 3.1571, -77.2803 }, lab{ 50, 0, -82.7485 }, 2.8615 },
+	{ lab{ 50, 2.8361, -74.0200 }, lab{ 50, 0, -82.7485 }, 3.4412 },
+	{ lab{ 50, -1.3802, -84.2814 }, lab{ 50, 0, -82.7485 }, 1.0000 },
+	{ lab{ 50, -1.1848, -84.8006 }, lab{ 50, 0, -82.7485 }, 1.0000 },
+	{ lab{ 50, -0.9009, -85.5211 }, lab{ 50, 0, -82.7485 }, 1.0000 },
+	{ lab{ 50, 0, 0 }, lab{ 50, -1, 2 }, 2.3669 },
+	{ lab{ 50, -1, 2 }, lab{ 50, 0, 0 }, 2.3669 },
+	{ lab{ 50, 2.4900, -0.0010 }, lab{ 50, -2.4900, 0.0009 }, 7.1792 },
+	{ lab{ 50, 2.4900, -0.0010 }, lab{ 50, -2.4900, 0.0010 }, 7.1792 },
+	{ lab{ 50, 2.4900, -0.0010 }, lab{ 50, -2.4900, 0.0011 }, 7.2195 },
+	{ lab{ 50, 2.4900, -0.0010 }, lab{ 50, -2.4900, 0.0012 }, 7.2195 },
+	{ lab{ 50, -0.0010, 2.4900 }, lab{ 50, 0.0009, -2.4900 }, 4.8045 },
+	{ lab{ 50, -0.0010, 2.4900 }, lab{ 50, 0.0010, -2.4900 }, 4.8045 },
+	{ lab{ 50, -0.0010, 2.4900 }, lab{ 50, 0.0011, -2.4900 }, 4.7461 },
+	{ lab{ 50, 2.5000, 0 }, lab{ 50, 0, -2.5000 }, 4.3065 },
+	{ lab{ 50, 2.5000, 0 }, lab{ 73, 25, -18 }, 27.1492 },
+	{ lab{ 50, 2.5000, 0 }, lab{ 61, -5, 29 }, 22.8977 },
+	{ lab{ 50, 2.5000, 0 }, lab{ 56, -27, -3 }, 31.9030 },
+	{ lab{ 50, 2.5000, 0 }, lab{ 58, 24, 15 }, 19.4535 },
+	{ lab{ 50, 2.5000, 0 }, lab{ 50, 3.1736, 0.5854 }, 1.0000 },
+	{ lab{ 50, 2.5000, 0 }, lab{ 50, 3.2972, 0 }, 1.0000 },
+	{ lab{ 50, 2.5000, 0 }, lab{ 50, 1.8634, 0.5757 }, 1.0000 },
+	{ lab{ 50, 2.5000, 0 }, lab{ 50, 3.2592, 0.3350 }, 1.0000 },
+	{ lab{ 60.2574, -34.0099, 36.2677 }, lab{ 60.4626, -34.1751, 39.4387 }, 1.2644 },
+	{ lab{ 63.0109, -31.0961, -5.8663 }, lab{ 62.8187, -29.7946, -4.0864 }, 1.2630 },
+	{ lab{ 61.2901, 3.7196, -5.3901 }, lab{ 61.4292, 2.2480, -4.9620 }, 1.8731 },
+	{ lab{ 35.0831, -44.1164, 3.7933 }, lab{ 35.0232, -40.0716, 1.5901 }, 1.8645 },
+	{ lab{ 22.7233, 20.0904, -46.6940 }, lab{ 23.0331, 14.9730, -42.5619 }, 2.0373 },
+	{ lab{ 36.4612, 47.8580, 18.3852 }, lab{ 36.2715, 50.5065, 21.2231 }, 1.4146 },
+	{ lab{ 90.8027, -2.0831, 1.4410 }, lab{ 91.1528, -1.6435, 0.0447 }, 1.4441 },
+	{ lab{ 90.9257, -0.5406, -0.9208 }, lab{ 88.6381, -0.8985, -0.7239 }, 1.5381 },
+	{ lab{ 6.7747, -0.2908, -2.4247 }, lab{ 5.8714, -0.0985, -2.2286 }, 0.6377 },
+	{ lab{ 2.0776, 0.0795, -1.1350 }, lab{ 0.9033, -0.0636, -0.5514 }, 0.9082 },
+}
+
+func TestCIEDE2000(t *testing.T) {
+	// The published values are rounded to 4 decimal places
+	const tol = 1e-4
+
+	for i, c := range ciede2000Pairs {
+		if got := c.p.ciede2000(c.q); math.Abs(got - c.de) > tol {
+			t.Errorf("pair %d: %v to %v is %.4f, want %.4f", i + 1, c.p, c.q, got, c.de)
+		}
+		if got := c.q.ciede2000(c.p); math.Abs(got - c.de) > tol {
+			t.Errorf("pair %d: %v to %v is %.4f, want %.4f", i + 1, c.q, c.p, got, c.de)
+		}
+		if got := c.p.ciede2000(c.p); got != 0 {
+			t.Errorf("pair %d: %v to itself is %v", i + 1, c.p, got)
+		}
+	}
+}
+
+// The metric is the same difference, scaled to 0-255, for every colour type
+func TestCIEDE2000Metric(t *testing.T) {
+	a := color.NRGBA{ 200, 40, 30, 0xff }
+	b := color.NRGBA{ 180, 60, 40, 0xff }
+
+	want := scaleDeltaE(labFromRGB(a.R, a.G, a.B).ciede2000(labFromRGB(b.R, b.G, b.B)))
+	if want == 0 || want == 255 {
+		t.Fatalf("difference %d doesn't test anything", want)
+	}
+	rm := CIEDE2000Metric.(RGBColorMetric)
+	if got := rm.DeltaRGB(a, b); got != want {
+		t.Errorf("DeltaRGB %d, want %d", got, want)
+	}
+	if got := CIEDE2000Metric.Delta(a, b); got != want {
+		t.Errorf("Delta %d, want %d", got, want)
+	}
+	if got := rm.DeltaRGB(a, a); got != 0 {
+		t.Errorf("DeltaRGB of the same colour %d", got)
+	}
+
+	// Only lightness differs between greys, where CIEDE2000 matches CIE76 at
+	// L* = 50
+	p, q := lab{ 45, 0, 0 }, lab{ 55, 0, 0 }
+	if de2000, de76 := p.ciede2000(q), p.cie76(q); math.Abs(de2000 - de76) > 1e-9 {
+		t.Errorf("greys either side of L* 50: CIEDE2000 %v, CIE76 %v", de2000, de76)
+	}
+}
@@ -3,6 +3,7 @@ package cv
 import (
 	"image"
 	"image/color"
+	"image/draw"
 	"math"
 )
 
@@ -14,6 +15,24 @@ func ImageDims(img image.Image) (w, h int) {
 	return img.Bounds().Dx(), img.Bounds().Dy()
 }
 
+// Returns how many luma samples there are per chroma sample, horizontally and
+// vertically
+func SubsampleFactors(ratio image.YCbCrSubsampleRatio) (hsub, vsub int) {
+	switch ratio {
+	case image.YCbCrSubsampleRatio422:
+		return 2, 1
+	case image.YCbCrSubsampleRatio420:
+		return 2, 2
+	case image.YCbCrSubsampleRatio440:
+		return 1, 2
+	case image.YCbCrSubsampleRatio411:
+		return 4, 1
+	case image.YCbCrSubsampleRatio410:
+		return 4, 2
+	}
+	return 1, 1
+}
+
 type Tuple struct {
 	First, Second int
 }
@@ -62,45 +81,36 @@ func Mean(vals []uint8) uint8 {
 }
 
 func AverageDeltaC(in image.Image, rowA, rowB int) uint8 {
-	w, h := in.Bounds().Dx(), in.Bounds().Dy()
-
-	total := 0
+	return AverageDeltaCMetric(in, rowA, rowB, DefaultMetric)
+}
 
-	switch v := in.(type) {
-	case *image.YCbCr:
-		hsub, vsub := 1, 1
-		switch v.SubsampleRatio {
-		case image.YCbCrSubsampleRatio422:
-			hsub, vsub = 2, 1
-		case image.YCbCrSubsampleRatio420:
-			hsub, vsub = 2, 2
-		}
+// As AverageDeltaC, measuring differences with m
+func AverageDeltaCMetric(in image.Image, rowA, rowB int, m ColorMetric) uint8 {
+	return AverageDeltaCROIMetric(in, rowA, rowB, in.Bounds(), m)
+}
 
-		if rowB - rowA < vsub {
-			if rowA > vsub {
-				rowA -= vsub - 1
-			} else if rowB < h - vsub {
-				rowB += vsub - 1
-			}
-		}
+func AverageDeltaCROI(in image.Image, rowA, rowB int, roi image.Rectangle) uint8 {
+	return AverageDeltaCROIMetric(in, rowA, rowB, roi, DefaultMetric)
+}
 
-		startYA := v.YOffset(0, rowA)
-		startCA := v.COffset(0, rowA)
-		startYB := v.YOffset(0, rowB)
-		startCB := v.COffset(0, rowB)
+// As AverageDeltaCROI, measuring differences with m
+func AverageDeltaCROIMetric(in image.Image, rowA, rowB int, roi image.Rectangle, m ColorMetric) uint8 {
+	m = metricOrDefault(m)
+	w := roi.Dx()
 
-		for x, subx := 0, 0; x < w; x, subx = x + hsub, subx + 1 {
-			s := color.YCbCr{ Y: v.Y[startYA + x], Cb: v.Cb[startCA + subx], Cr: v.Cr[startCA + subx] }
-			d := color.YCbCr{ Y: v.Y[startYB + x], Cb: v.Cb[startCB + subx], Cr: v.Cr[startCB + subx] }
+	total := 0
 
-			diff := DeltaC(s, d)
-			total += int(diff)
+	if v, ok := yuvPlanesOf(in); ok {
+		rowA, rowB = v.spreadRows(rowA, rowB, in.Bounds())
+		total = v.sumRowDeltas(roi.Min.X, roi.Max.X, rowA, rowB, m)
+	} else if p, ok := rgbPixelsOf(in); ok {
+		rm := rgbMetricOf(m)
+		for x := roi.Min.X; x < roi.Max.X; x++ {
+			total += int(p.delta(in, rm, x, rowA, x, rowB))
 		}
-		total *= hsub
-	default:
-		_ = v
+	} else {
 		for x := 0; x < w; x++ {
-			diff := color.Gray{DeltaC(in.At(x, rowA), in.At(x, rowB))}
+			diff := color.Gray{m.Delta(in.At(x + roi.Min.X, rowA), in.At(x + roi.Min.X, rowB))}
 			total += int(diff.Y)
 		}
 	}
@@ -108,46 +118,28 @@ func AverageDeltaC(in image.Image, rowA, rowB int) uint8 {
 	return uint8(total / w)
 }
 
-func AverageDeltaCROI(in image.Image, rowA, rowB int, roi image.Rectangle) uint8 {
-	w, h := roi.Dx(), in.Bounds().Dy()
-
-	total := 0
-
-	switch v := in.(type) {
-	case *image.YCbCr:
-		hsub, vsub := 1, 1
-		switch v.SubsampleRatio {
-		case image.YCbCrSubsampleRatio422:
-			hsub, vsub = 2, 1
-		case image.YCbCrSubsampleRatio420:
-			hsub, vsub = 2, 2
-		}
-
-		if rowB - rowA < vsub {
-			if rowA > vsub {
-				rowA -= vsub - 1
-			} else if rowB < h - vsub {
-				rowB += vsub - 1
-			}
-		}
+func AverageDeltaCROIConst(in image.Image, row int, d color.Color, roi image.Rectangle) uint8 {
+	return AverageDeltaCROIConstMetric(in, row, d, roi, DefaultMetric)
+}
 
-		startYA := v.YOffset(roi.Min.X, rowA)
-		startCA := v.COffset(roi.Min.X, rowA)
-		startYB := v.YOffset(roi.Min.X, rowB)
-		startCB := v.COffset(roi.Min.X, rowB)
+// As AverageDeltaCROIConst, measuring differences with m
+func AverageDeltaCROIConstMetric(in image.Image, row int, d color.Color, roi image.Rectangle, m ColorMetric) uint8 {
+	m = metricOrDefault(m)
+	w := roi.Dx()
 
-		for x, subx := 0, 0; x < w; x, subx = x + hsub, subx + 1 {
-			s := color.YCbCr{ Y: v.Y[startYA + x], Cb: v.Cb[startCA + subx], Cr: v.Cr[startCA + subx] }
-			d := color.YCbCr{ Y: v.Y[startYB + x], Cb: v.Cb[startCB + subx], Cr: v.Cr[startCB + subx] }
+	total := 0
 
-			diff := DeltaC(s, d)
-			total += int(diff)
+	if v, ok := yuvPlanesOf(in); ok {
+		total = v.sumConstDeltas(roi.Min.X, roi.Max.X, row, d, m)
+	} else if p, ok := rgbPixelsOf(in); ok {
+		rm := rgbMetricOf(m)
+		dn, dok := constNRGBA(m, d)
+		for x := roi.Min.X; x < roi.Max.X; x++ {
+			total += int(p.deltaConst(in, rm, x, row, d, dn, dok))
 		}
-		total *= hsub
-	default:
-		_ = v
+	} else {
 		for x := 0; x < w; x++ {
-			diff := color.Gray{DeltaC(in.At(x + roi.Min.X, rowA), in.At(x + roi.Min.X, rowB))}
+			diff := color.Gray{m.Delta(in.At(x + roi.Min.X, row), d)}
 			total += int(diff.Y)
 		}
 	}
@@ -155,39 +147,63 @@ func AverageDeltaCROI(in image.Image, rowA, rowB int, roi image.Rectangle) uint8
 	return uint8(total / w)
 }
 
-func AverageDeltaCROIConst(in image.Image, row int, d color.Color, roi image.Rectangle) uint8 {
-	w, _ := roi.Dx(), in.Bounds().Dy()
-
-	total := 0
+// Returns the mean colour of roi in in, as a color.YCbCr for *image.YCbCr and
+// a color.NRGBA for everything else. nil if roi is empty.
+func MeanColorROI(in image.Image, roi image.Rectangle) color.Color {
+	roi = roi.Intersect(in.Bounds())
+	n := roi.Dx() * roi.Dy()
+	if n == 0 {
+		return nil
+	}
 
 	switch v := in.(type) {
 	case *image.YCbCr:
-		hsub, _ := 1, 1
-		switch v.SubsampleRatio {
-		case image.YCbCrSubsampleRatio422:
-			hsub, _ = 2, 1
-		case image.YCbCrSubsampleRatio420:
-			hsub, _ = 2, 2
-		}
-
-		startYA := v.YOffset(roi.Min.X, row)
-		startCA := v.COffset(roi.Min.X, row)
-
-		for x, subx := 0, 0; x < w; x, subx = x + hsub, subx + 1 {
-			s := color.YCbCr{ Y: v.Y[startYA + x], Cb: v.Cb[startCA + subx], Cr: v.Cr[startCA + subx] }
-			diff := DeltaC(s, d)
-			total += int(diff)
+		var y, cb, cr int
+		for j := roi.Min.Y; j < roi.Max.Y; j++ {
+			for i := roi.Min.X; i < roi.Max.X; i++ {
+				yoff := v.YOffset(i, j)
+				coff := v.COffset(i, j)
+				y += int(v.Y[yoff])
+				cb += int(v.Cb[coff])
+				cr += int(v.Cr[coff])
+			}
 		}
-		total *= hsub
+		return color.YCbCr{ Y: uint8(y / n), Cb: uint8(cb / n), Cr: uint8(cr / n) }
 	default:
-		_ = v
-		for x := 0; x < w; x++ {
-			diff := color.Gray{DeltaC(in.At(x + roi.Min.X, row), d)}
-			total += int(diff.Y)
+		var r, g, b, a int
+		for j := roi.Min.Y; j < roi.Max.Y; j++ {
+			for i := roi.Min.X; i < roi.Max.X; i++ {
+				pix := color.NRGBAModel.Convert(v.At(i, j)).(color.NRGBA)
+				r += int(pix.R)
+				g += int(pix.G)
+				b += int(pix.B)
+				a += int(pix.A)
+			}
 		}
+		return color.NRGBA{ R: uint8(r / n), G: uint8(g / n), B: uint8(b / n), A: uint8(a / n) }
 	}
+}
 
-	return uint8(total / w)
+// Returns the luma of in as a *image.Gray. *image.Gray images are returned
+// as-is, and *image.YCbCr ones just have their Y plane copied.
+func ToGray(in image.Image) *image.Gray {
+	switch v := in.(type) {
+	case *image.Gray:
+		return v
+	case *image.YCbCr:
+		w, h := ImageDims(v)
+		out := image.NewGray(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			start := v.YOffset(v.Rect.Min.X, v.Rect.Min.Y + y)
+			copy(out.Pix[y * out.Stride : y * out.Stride + w], v.Y[start : start + w])
+		}
+		return out
+	}
+
+	b := in.Bounds()
+	out := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(out, out.Bounds(), in, b.Min, draw.Src)
+	return out
 }
 
 type RawYCbCrColor struct {
@@ -343,6 +359,10 @@ func absdiff_uint8(a, b uint8) int {
 }
 
 func DeltaCNRGBA(a, b color.NRGBA) uint8 {
+	return uint8(deltaNRGBA(a, b))
+}
+
+func deltaNRGBA(a, b color.NRGBA) float64 {
 	deltaR := float64(absdiff_uint8(a.R, b.R))
 	deltaG := float64(absdiff_uint8(a.G, b.G))
 	deltaB := float64(absdiff_uint8(a.B, b.B))
@@ -352,15 +372,19 @@ func DeltaCNRGBA(a, b color.NRGBA) uint8 {
 			    (3 * deltaB * deltaB) +
 			    (deltaR * ((deltaR * deltaR) - (deltaB * deltaB)) / 256.0))
 
-	return uint8(deltaC)
+	return deltaC
 }
 
+// Plain Euclidean distance. See ColorMetric for alternatives
 func DeltaCYCbCr(a, b color.YCbCr) uint8 {
-	// FIXME: What do?
+	return uint8(deltaYCbCr(a, b))
+}
+
+func deltaYCbCr(a, b color.YCbCr) float64 {
 	ydiff := float64(absdiff_uint8(a.Y, b.Y)) / 255.0
 	cbdiff := float64(absdiff_uint8(a.Cb, b.Cb)) / 255.0
 	crdiff := float64(absdiff_uint8(a.Cr, b.Cr)) / 255.0
-	return uint8(math.Sqrt(ydiff *ydiff + cbdiff * cbdiff + crdiff * crdiff) * 255.0)
+	return math.Sqrt(ydiff *ydiff + cbdiff * cbdiff + crdiff * crdiff) * 255.0
 }
 
 func DeltaC(a, b color.Color) uint8 {
@@ -396,131 +420,30 @@ func DeltaC(a, b color.Color) uint8 {
 }
 
 func DeltaCByRow(in image.Image) *image.Gray {
-	w, h := in.Bounds().Dx(), in.Bounds().Dy()
-	var out *image.Gray
-
-	switch v := in.(type) {
-	case *image.YCbCr:
-		hsub, vsub := 1, 1
-		switch v.SubsampleRatio {
-		case image.YCbCrSubsampleRatio422:
-			hsub, vsub = 2, 1
-		case image.YCbCrSubsampleRatio420:
-			hsub, vsub = 2, 2
-		}
-		cols, rows := w / hsub, h / vsub
-		out = image.NewGray(image.Rect(0, 0, cols, rows - 1))
-		for x, subx := 0, 0; x < w; x, subx = x + hsub, subx + 1 {
-			for y, suby := 0, 0; y < h - vsub; y, suby = y + vsub, suby + 1 {
-				yoff := v.YOffset(x, y)
-				coff := v.COffset(x, y)
-				s := color.YCbCr{ Y: v.Y[yoff], Cb: v.Cb[coff], Cr: v.Cr[coff] }
-
-				yoff += v.YStride * vsub
-				coff += v.CStride
-				d := color.YCbCr{ Y: v.Y[yoff], Cb: v.Cb[coff], Cr: v.Cr[coff] }
-
-				diff := DeltaCYCbCr(s, d)
-				out.Pix[suby * out.Stride + subx] = diff
-			}
-		}
-	default:
-		_ = v
-		out = image.NewGray(image.Rect(0, 0, w, h - 1))
-		for x := 0; x < w; x++ {
-			for y := 0; y < h - 1; y++ {
-				diff := color.Gray{DeltaC(in.At(x, y), in.At(x, y + 1))}
-				out.SetGray(x, y, diff)
-			}
-		}
-	}
+	return DeltaCByRowMetric(in, DefaultMetric)
+}
 
-	return out
+// As DeltaCByRow, measuring differences with m
+func DeltaCByRowMetric(in image.Image, m ColorMetric) *image.Gray {
+	return DeltaCByRowInto(nil, in, in.Bounds(), m, nil)
 }
 
 func DeltaCByRowROI(in image.Image, roi image.Rectangle) *image.Gray {
-	w, h := roi.Dx(), roi.Dy()
-	var out *image.Gray
-
-	switch v := in.(type) {
-	case *image.YCbCr:
-		hsub, vsub := 1, 1
-		switch v.SubsampleRatio {
-		case image.YCbCrSubsampleRatio422:
-			hsub, vsub = 2, 1
-		case image.YCbCrSubsampleRatio420:
-			hsub, vsub = 2, 2
-		}
-		cols, rows := w / hsub, h / vsub
-		out = image.NewGray(image.Rect(0, 0, cols, rows - 1))
-		for x, subx := 0, 0; x < w; x, subx = x + hsub, subx + 1 {
-			for y, suby := 0, 0; y < h - vsub; y, suby = y + vsub, suby + 1 {
-				yoff := v.YOffset(x + roi.Min.X, y + roi.Min.Y)
-				coff := v.COffset(x + roi.Min.X, y + roi.Min.Y)
-				s := color.YCbCr{ Y: v.Y[yoff], Cb: v.Cb[coff], Cr: v.Cr[coff] }
-
-				yoff += v.YStride * vsub
-				coff += v.CStride
-				d := color.YCbCr{ Y: v.Y[yoff], Cb: v.Cb[coff], Cr: v.Cr[coff] }
-
-				diff := DeltaCYCbCr(s, d)
-				out.Pix[suby * out.Stride + subx] = diff
-			}
-		}
-	default:
-		_ = v
-		out = image.NewGray(image.Rect(0, 0, w, h - 1))
-		for x := 0; x < w; x++ {
-			for y := 0; y < h - 1; y++ {
-				diff := color.Gray{DeltaC(in.At(x + roi.Min.X, y + roi.Min.Y), in.At(x + roi.Min.X, y + roi.Min.Y + 1))}
-				out.SetGray(x, y, diff)
-			}
-		}
-	}
+	return DeltaCByRowROIMetric(in, roi, DefaultMetric)
+}
 
-	return out
+// As DeltaCByRowROI, measuring differences with m
+func DeltaCByRowROIMetric(in image.Image, roi image.Rectangle, m ColorMetric) *image.Gray {
+	return DeltaCByRowInto(nil, in, roi, m, nil)
 }
 
 func DeltaCByCol(in image.Image) *image.Gray {
-	w, h := in.Bounds().Dx(), in.Bounds().Dy()
-	var out *image.Gray
-
-	switch v := in.(type) {
-	case *image.YCbCr:
-		hsub, vsub := 1, 1
-		switch v.SubsampleRatio {
-		case image.YCbCrSubsampleRatio422:
-			hsub, vsub = 2, 1
-		case image.YCbCrSubsampleRatio420:
-			hsub, vsub = 2, 2
-		}
-		cols, rows := w / hsub, h / vsub
-		out = image.NewGray(image.Rect(0, 0, cols - 1, rows))
-
-		for y, suby := 0, 0; y < h; y, suby = y + vsub, suby + 1 {
-			for x, subx := 0, 0; x < w - hsub; x, subx = x + hsub, subx + 1 {
-				yoff := v.YOffset(x, y)
-				coff := v.COffset(x, y)
-
-				s := color.YCbCr{ Y: v.Y[yoff], Cb: v.Cb[coff], Cr: v.Cr[coff] }
-				d := color.YCbCr{ Y: v.Y[yoff + hsub], Cb: v.Cb[coff + 1], Cr: v.Cr[coff + 1] }
-				diff := DeltaCYCbCr(s, d)
-				out.Pix[suby * out.Stride + subx] = diff
-			}
-		}
-
-	default:
-		_ = v
-		out = image.NewGray(image.Rect(0, 0, w, h - 1))
-		for y := 0; y < h; y++ {
-			for x := 0; x < w - 1; x++ {
-				diff := color.Gray{DeltaC(in.At(x, y), in.At(x + 1, y))}
-				out.SetGray(x, y, diff)
-			}
-		}
-	}
+	return DeltaCByColMetric(in, DefaultMetric)
+}
 
-	return out
+// As DeltaCByCol, measuring differences with m
+func DeltaCByColMetric(in image.Image, m ColorMetric) *image.Gray {
+	return DeltaCByColInto(nil, in, in.Bounds(), m, nil)
 }
 
 func min(a, b int) int {
@@ -538,8 +461,13 @@ func max(a, b int) int {
 }
 
 func FindHorizontalLines(img *image.Gray) *image.Gray {
+	return FindHorizontalLinesStripes(img, 32)
+}
+
+// As FindHorizontalLines, summing over stripes 1/stripes of the image high
+func FindHorizontalLinesStripes(img *image.Gray, stripes int) *image.Gray {
 	w, h := img.Bounds().Dx(), img.Bounds().Dy()
-	stripeH := max(1, int(float64(h) / 32))
+	stripeH := max(1, int(float64(h) / float64(stripes)))
 	scale := 255.0 / float64(w * stripeH)
 
 	sums := SumLines(img)
@@ -558,8 +486,13 @@ func FindHorizontalLines(img *image.Gray) *image.Gray {
 }
 
 func FindVerticalLines(img *image.Gray) *image.Gray {
+	return FindVerticalLinesStripes(img, 32)
+}
+
+// As FindVerticalLines, summing over stripes 1/stripes of the image wide
+func FindVerticalLinesStripes(img *image.Gray, stripes int) *image.Gray {
 	w, h := img.Bounds().Dx(), img.Bounds().Dy()
-	stripeW := max(1, int(float64(w) / 32))
+	stripeW := max(1, int(float64(w) / float64(stripes)))
 	scale := 255.0 / float64(h * stripeW)
 
 	sums := SumColumns(img)
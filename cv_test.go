@@ -0,0 +1,166 @@
+package cv
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+var testRatios = []image.YCbCrSubsampleRatio{
+	image.YCbCrSubsampleRatio444,
+	image.YCbCrSubsampleRatio422,
+	image.YCbCrSubsampleRatio420,
+	image.YCbCrSubsampleRatio440,
+	image.YCbCrSubsampleRatio411,
+	image.YCbCrSubsampleRatio410,
+}
+
+// Odd-sized, not at the origin, and filled with noise. Values are kept below
+// 128 so that no difference is over 255.
+func noisyYCbCr(rnd *rand.Rand, r image.Rectangle, ratio image.YCbCrSubsampleRatio) *image.YCbCr {
+	img := image.NewYCbCr(r, ratio)
+	for _, p := range [][]uint8{ img.Y, img.Cb, img.Cr } {
+		for i := range p {
+			p[i] = uint8(rnd.Intn(128))
+		}
+	}
+	return img
+}
+
+// The fast paths take the exact square root, which can be 1 higher than
+// DeltaC
+func checkNear(t *testing.T, what string, got, want int) {
+	t.Helper()
+	if got != want && got != want + 1 {
+		t.Errorf("%s: got %d, want %d", what, got, want)
+	}
+}
+
+func refDelta(img *image.YCbCr, x0, y0, x1, y1 int) int {
+	return int(DeltaCYCbCr(img.YCbCrAt(x0, y0), img.YCbCrAt(x1, y1)))
+}
+
+// Rows in the same chroma row are moved apart, as AverageDeltaC does
+func refSpreadRows(rowA, rowB, vsub int, b image.Rectangle) (int, int) {
+	if vsub == 1 || rowA / vsub != rowB / vsub {
+		return rowA, rowB
+	}
+	if first := rowA / vsub * vsub; first > b.Min.Y {
+		return first - 1, rowB
+	}
+	if next := rowB / vsub * vsub + vsub; next < b.Max.Y {
+		return rowA, next
+	}
+	return rowA, rowB
+}
+
+// Each pixel is compared using the luma at the start of its chroma sample,
+// clipped to the ROI, so that each chroma sample is only looked at once
+func refAverage(img *image.YCbCr, rowA, rowB int, roi image.Rectangle, hsub int) int {
+	total := 0
+	for x := roi.Min.X; x < roi.Max.X; x++ {
+		xs := max(roi.Min.X, x / hsub * hsub)
+		total += refDelta(img, xs, rowA, xs, rowB)
+	}
+	return total / roi.Dx()
+}
+
+func refAverageConst(img *image.YCbCr, row int, d color.Color, roi image.Rectangle, hsub int) int {
+	total := 0
+	for x := roi.Min.X; x < roi.Max.X; x++ {
+		xs := max(roi.Min.X, x / hsub * hsub)
+		total += int(DeltaC(img.YCbCrAt(xs, row), d))
+	}
+	return total / roi.Dx()
+}
+
+func checkDeltaRows(t *testing.T, name string, img *image.YCbCr, roi image.Rectangle, out *image.Gray, byCol bool) {
+	t.Helper()
+	hsub, vsub := SubsampleFactors(img.SubsampleRatio)
+
+	want := DeltaCByRowBounds(img, roi)
+	if byCol {
+		want = DeltaCByColBounds(img, roi)
+	}
+	if out.Bounds() != want {
+		t.Errorf("%s: bounds %v, want %v", name, out.Bounds(), want)
+		return
+	}
+
+	for y := 0; y < want.Dy(); y++ {
+		for x := 0; x < want.Dx(); x++ {
+			lx, ly := roi.Min.X + x * hsub, roi.Min.Y + y * vsub
+			ref := 0
+			if byCol {
+				ref = refDelta(img, lx, ly, lx + hsub, ly)
+			} else {
+				ref = refDelta(img, lx, ly, lx, ly + vsub)
+			}
+			checkNear(t, name, int(out.GrayAt(x, y).Y), ref)
+		}
+	}
+}
+
+func TestYCbCrFastPaths(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	pool := NewBandPool(3)
+	defer pool.Close()
+
+	for _, ratio := range testRatios {
+		hsub, vsub := SubsampleFactors(ratio)
+
+		full := noisyYCbCr(rnd, image.Rect(3, 5, 56, 46), ratio)
+		sub := full.SubImage(image.Rect(6, 8, 52, 43)).(*image.YCbCr)
+
+		for _, img := range []*image.YCbCr{ full, sub } {
+			b := img.Bounds()
+			name := ratio.String() + " " + b.String()
+
+			checkDeltaRows(t, name + " DeltaCByRow", img, b, DeltaCByRow(img), false)
+			checkDeltaRows(t, name + " DeltaCByCol", img, b, DeltaCByCol(img), true)
+
+			rows := []int{ b.Min.Y, b.Min.Y + 1, b.Min.Y + 7, b.Max.Y - 1 }
+			for _, rowA := range rows {
+				for _, rowB := range rows {
+					ra, rb := refSpreadRows(rowA, rowB, vsub, b)
+					checkNear(t, name + " AverageDeltaC", int(AverageDeltaC(img, rowA, rowB)),
+						refAverage(img, ra, rb, b, hsub))
+				}
+			}
+
+			rois := []image.Rectangle{
+				b,
+				image.Rect(b.Min.X + 1, b.Min.Y + 3, b.Max.X - 2, b.Max.Y - 1),
+				image.Rect(b.Min.X + 5, b.Min.Y + 1, b.Min.X + 30, b.Min.Y + 24),
+			}
+			for _, roi := range rois {
+				rname := name + " roi " + roi.String()
+
+				checkDeltaRows(t, rname + " DeltaCByRowROI", img, roi, DeltaCByRowROI(img, roi), false)
+				for _, p := range []*BandPool{ nil, pool } {
+					checkDeltaRows(t, rname + " DeltaCByRowInto", img, roi, DeltaCByRowInto(nil, img, roi, nil, p), false)
+					checkDeltaRows(t, rname + " DeltaCByColInto", img, roi, DeltaCByColInto(nil, img, roi, nil, p), true)
+				}
+
+				for _, rows := range [][2]int{
+					{ roi.Min.Y + 2, roi.Min.Y + 9 },
+					{ roi.Min.Y + 3, roi.Min.Y + 3 },
+					{ roi.Min.Y + 4, roi.Min.Y + 5 },
+					{ roi.Max.Y - 2, roi.Max.Y - 1 },
+				} {
+					ra, rb := refSpreadRows(rows[0], rows[1], vsub, b)
+					checkNear(t, rname + " AverageDeltaCROI", int(AverageDeltaCROI(img, rows[0], rows[1], roi)),
+						refAverage(img, ra, rb, roi, hsub))
+
+					for _, pc := range DefaultPalette() {
+						got := int(AverageDeltaCROIConst(img, rows[0], pc.Color, roi))
+						if want := refAverageConst(img, rows[0], pc.Color, roi, hsub); got != want {
+							t.Errorf("%s AverageDeltaCROIConst %s: got %d, want %d", rname, pc.Name, got, want)
+						}
+					}
+				}
+			}
+		}
+	}
+}
@@ -1,82 +1,50 @@
 package cv
 
 import (
-	"fmt"
 	"image"
-	"image/color"
-	"image/draw"
 	"math"
+	"os"
 )
 
 func RunAlgorithm(in, out image.Image, profile bool) image.Image {
-	// Find and amplify edges
-	diff := DeltaCByCol(in)
-	minMax := MinMaxRowwise(diff)
-	ExpandContrastRowWise(diff, minMax)
-	Threshold(diff, 128)
-
-	// Attempt to ignore noisy rows (likely above/below the target)
-	w, h := ImageDims(diff)
-	for y := 0; y < h; y++ {
-		row := diff.Pix[y * diff.Stride : y * diff.Stride + w]
-		blobs := FindBlobs(row)
-		if len(blobs) != 2 {
-			for x := 0; x < len(row); x++ {
-				row[x] = 0
-			}
-		}
-	}
-
-	// Find vertical lines in the non-noisy bits
-	summed := FindVerticalLines(diff)
-	minMax = MinMaxRowwise(summed)
-	ExpandContrastRowWise(summed, minMax)
-	Threshold(summed, 128)
+	opts := DefaultOptions()
 	if !profile {
-		fmt.Println(summed.Pix)
+		opts.Tracer = PrintTracer{ W: os.Stdout }
 	}
+	return RunAlgorithmOpts(in, out, profile, opts)
+}
 
-	// Hopefully we're left with exactly two blobs, marking the edges
-	// TODO: Should handle 1 (one edge only) and 0 (full FoV filled) blob too
-	blobs := FindBlobs(summed.Pix)
-	scale := in.Bounds().Dx() / len(summed.Pix)
-
-	if !profile {
-		fmt.Println("Blobs", blobs)
-	}
+func RunAlgorithmOpts(in, out image.Image, profile bool, opts *Options) image.Image {
+	opts = opts.orDefault()
 
-	var target = Tuple{ 0, in.Bounds().Dx() }
-	if len(blobs) == 2 {
-		target = Tuple{
-			RoundUp((blobs[0].First + blobs[0].Second) * scale / 2, 2),
-			RoundUp((blobs[1].First + blobs[1].Second) * scale / 2, 2),
-		}
-	}
+	var det BoardDetection
+	target := findBoardTarget(in, nil, &det, opts)
 
-	if !profile {
-		fmt.Println("target", target)
-	}
+	opts.trace("dev.state", det.State)
+	opts.trace("dev.target", target)
 
 	targetColor := in.(*image.YCbCr).YCbCrAt((target.First + target.Second) / 2, in.Bounds().Dy() / 2)
-	if !profile {
-		fmt.Println("targetColor", targetColor)
+	opts.trace("dev.target_color", targetColor)
+	if opts.Tracer != nil {
+		dists := DefaultPalette().DistancesMetric(targetColor, opts.metric())
+		opts.trace("dev.class", nearest(dists, opts.PaletteMatchThreshold))
+		opts.trace("dev.class_distances", dists)
 	}
 
 	//horz := FindHorizonROI(in, image.Rect(target.First, 0, target.Second, in.Bounds().Dy()))
 	horz := float32(math.NaN())
 	roi := image.Rect(target.First, 0, target.Second, in.Bounds().Dy())
+	diff := DeltaCByRowROIMetric(in, roi, opts.metric())
 	{
-		diff := DeltaCByRowROI(in, roi)
 		minMax := MinMaxColwise(diff)
 		ExpandContrastColWise(diff, minMax)
-		Threshold(diff, 128)
-
-		return diff
+		opts.EdgeThreshold.Apply(diff)
+		opts.trace("dev.row_delta.threshold", diff)
 
-		summed := FindHorizontalLines(diff)
+		summed := FindHorizontalLinesStripes(diff, opts.LineStripes)
 		minMax = MinMaxColwise(summed)
 		ExpandContrastColWise(summed, minMax)
-		Threshold(summed, 128)
+		opts.LineThreshold.Apply(summed)
 
 
 		blobs := FindBlobs(summed.Pix)
@@ -89,15 +57,13 @@ func RunAlgorithm(in, out image.Image, profile bool) image.Image {
 
 		avgs := make([]uint8, 0, len(blobs))
 		for _, b := range blobs {
-			avgs = append(avgs, AverageDeltaCROIConst(in, b.First * scale, targetColor, roi))
+			avgs = append(avgs, AverageDeltaCROIConstMetric(in, roi.Min.Y + b.First * scale, targetColor, roi, opts.metric()))
 		}
 
-		if !profile {
-			fmt.Println("Avgs:", avgs)
-		}
+		opts.trace("dev.avgs", avgs)
 
 		min := uint8(255)
-		minIdx := -1
+		minIdx := 0
 		for i, m := range avgs {
 			if m < min {
 				min = m
@@ -106,9 +72,7 @@ func RunAlgorithm(in, out image.Image, profile bool) image.Image {
 		}
 
 		b := blobs[minIdx]
-		if !profile {
-			fmt.Println("Blob", minIdx, "at", b)
-		}
+		opts.trace("dev.chosen", minIdx)
 		horz = float32((b.First + b.Second + 1) / 2) / float32(len(summed.Pix))
 	}
 eh:
@@ -116,14 +80,14 @@ eh:
 	if !profile && out != nil {
 		bottom := out.Bounds().Dy()
 
-		if float64(horz) != math.NaN() {
+		if !math.IsNaN(float64(horz)) {
 			bottom = int(horz * float32(out.Bounds().Dy()))
 		}
 
-		red := &image.Uniform{color.RGBA{0x80, 0, 0, 0x80}}
-
-		rect := image.Rect(target.First, 0, target.Second, bottom)
-		draw.Draw(out.(draw.Image), rect, red, image.ZP, draw.Over)
+		if o, err := NewOverlay(out); err == nil {
+			o.FillRect(image.Rect(target.First, 0, target.Second, bottom), OverlayHighlight)
+			o.HorizonRow(horz, OverlayHorizon)
+		}
 	}
 
 	//summed := FindVerticalLines(diff)
@@ -131,5 +95,5 @@ eh:
 	//ExpandContrastRowWise(summed, minMax)
 	//Threshold(summed, 150)
 
-	return nil
+	return diff
 }
@@ -0,0 +1,70 @@
+package cv
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestRunAlgorithmOverlay(t *testing.T) {
+	frame, truth := DefaultScene().Render()
+	w, h := ImageDims(frame)
+
+	out := image.NewRGBA(frame.Bounds())
+	draw.Draw(out, out.Bounds(), frame, frame.Bounds().Min, draw.Src)
+	orig := image.NewRGBA(frame.Bounds())
+	draw.Draw(orig, orig.Bounds(), frame, frame.Bounds().Min, draw.Src)
+
+	opts := DefaultOptions()
+	diff := RunAlgorithmOpts(frame, out, false, opts)
+	if diff == nil || diff.Bounds().Empty() {
+		t.Fatalf("no row delta image returned")
+	}
+
+	board := truth.Boards[0]
+	y := h / 4
+	inside := (board.PixLeft + board.PixRight) / 2
+	if out.RGBAAt(inside, y) == orig.RGBAAt(inside, y) {
+		t.Errorf("board at (%d, %d) not highlighted", inside, y)
+	}
+
+	outside := board.PixLeft / 2
+	if out.RGBAAt(outside, y) != orig.RGBAAt(outside, y) {
+		t.Errorf("wall at (%d, %d) highlighted", outside, y)
+	}
+
+	// profile skips the overlay altogether
+	prof := image.NewRGBA(frame.Bounds())
+	draw.Draw(prof, prof.Bounds(), frame, frame.Bounds().Min, draw.Src)
+	RunAlgorithmOpts(frame, prof, true, opts)
+	for x := 0; x < w; x++ {
+		if prof.RGBAAt(x, y) != orig.RGBAAt(x, y) {
+			t.Fatalf("profile run drew on the output at (%d, %d)", x, y)
+		}
+	}
+}
+
+// Both halves are exactly 255 from the target colour in the middle, so every
+// horizontal line's average saturates
+func TestRunAlgorithmNoMatch(t *testing.T) {
+	w, h := 64, 64
+	frame := image.NewYCbCr(image.Rect(0, 0, w, h), image.YCbCrSubsampleRatio444)
+	for y := 0; y < h; y++ {
+		c := color.YCbCr{ 255, 0, 128 }
+		if y >= h / 2 {
+			c = color.YCbCr{ 0, 255, 128 }
+		}
+		for x := 0; x < w; x++ {
+			frame.Y[frame.YOffset(x, y)] = c.Y
+			frame.Cb[frame.COffset(x, y)] = c.Cb
+			frame.Cr[frame.COffset(x, y)] = c.Cr
+		}
+	}
+	frame.Y[frame.YOffset(w / 2, h / 2)] = 0
+	frame.Cb[frame.COffset(w / 2, h / 2)] = 0
+	frame.Cr[frame.COffset(w / 2, h / 2)] = 128
+
+	out := image.NewRGBA(frame.Bounds())
+	RunAlgorithmOpts(frame, out, false, DefaultOptions())
+}
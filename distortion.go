@@ -0,0 +1,207 @@
+package cv
+
+import (
+	"image"
+	"math"
+)
+
+// Brown-Conrady lens distortion, applied to normalised camera coordinates
+// (i.e. (px - CX) / FocalX)
+type Distortion struct {
+	// Radial
+	K1 float64 `json:"k1"`
+	K2 float64 `json:"k2"`
+	K3 float64 `json:"k3"`
+	// Tangential
+	P1 float64 `json:"p1"`
+	P2 float64 `json:"p2"`
+}
+
+func (d Distortion) IsZero() bool {
+	return d == Distortion{}
+}
+
+// Maps an ideal (undistorted) point to where the lens actually puts it
+func (d Distortion) Distort(x, y float64) (float64, float64) {
+	r2 := x * x + y * y
+	radial := 1 + r2 * (d.K1 + r2 * (d.K2 + r2 * d.K3))
+
+	xd := x * radial + 2 * d.P1 * x * y + d.P2 * (r2 + 2 * x * x)
+	yd := y * radial + d.P1 * (r2 + 2 * y * y) + 2 * d.P2 * x * y
+
+	return xd, yd
+}
+
+// Inverse of Distort, by fixed-point iteration. Good enough for the mild
+// distortion of typical small wide-angle lenses.
+func (d Distortion) Undistort(xd, yd float64) (float64, float64) {
+	x, y := xd, yd
+	for i := 0; i < 20; i++ {
+		r2 := x * x + y * y
+		radial := 1 + r2 * (d.K1 + r2 * (d.K2 + r2 * d.K3))
+		dx := 2 * d.P1 * x * y + d.P2 * (r2 + 2 * x * x)
+		dy := d.P1 * (r2 + 2 * y * y) + 2 * d.P2 * x * y
+
+		nx, ny := (xd - dx) / radial, (yd - dy) / radial
+		if math.Abs(nx - x) < 1e-9 && math.Abs(ny - y) < 1e-9 {
+			return nx, ny
+		}
+		x, y = nx, ny
+	}
+
+	return x, y
+}
+
+// Takes normalised image coordinates of a point in a distorted frame (e.g. a
+// detection) to where it would be in an undistorted one
+func (c *Camera) UndistortPoint(x, y float32) (float32, float32) {
+	xd := (float64(x) * float64(c.Width) - c.CX) / c.FocalX
+	yd := (float64(y) * float64(c.Height) - c.CY) / c.FocalY
+
+	xu, yu := c.Distortion.Undistort(xd, yd)
+
+	return float32((xu * c.FocalX + c.CX) / float64(c.Width)),
+		float32((yu * c.FocalY + c.CY) / float64(c.Height))
+}
+
+// The opposite of UndistortPoint
+func (c *Camera) DistortPoint(x, y float32) (float32, float32) {
+	xu := (float64(x) * float64(c.Width) - c.CX) / c.FocalX
+	yu := (float64(y) * float64(c.Height) - c.CY) / c.FocalY
+
+	xd, yd := c.Distortion.Distort(xu, yu)
+
+	return float32((xd * c.FocalX + c.CX) / float64(c.Width)),
+		float32((yd * c.FocalY + c.CY) / float64(c.Height))
+}
+
+// Precomputed nearest-neighbour lookup tables for undistorting whole frames
+// from a particular camera. Safe for concurrent use once built.
+type Remap struct {
+	Width, Height int
+	SubsampleRatio image.YCbCrSubsampleRatio
+
+	// For each destination pixel, the index of the source pixel within its
+	// plane (with the plane's stride equal to its width)
+	luma []int32
+	chroma []int32
+	cw, ch int
+}
+
+// Builds a remap for frames of the camera's resolution. ratio is only used
+// for *image.YCbCr frames.
+func NewRemap(c *Camera, ratio image.YCbCrSubsampleRatio) *Remap {
+	r := &Remap{
+		Width: c.Width,
+		Height: c.Height,
+		SubsampleRatio: ratio,
+	}
+
+	r.luma = buildRemapTable(c, c.Width, c.Height, 1, 1)
+
+	hsub, vsub := SubsampleFactors(ratio)
+	r.cw, r.ch = (c.Width + hsub - 1) / hsub, (c.Height + vsub - 1) / vsub
+	r.chroma = buildRemapTable(c, r.cw, r.ch, hsub, vsub)
+
+	return r
+}
+
+func buildRemapTable(c *Camera, w, h, hsub, vsub int) []int32 {
+	table := make([]int32, w * h)
+
+	for y := 0; y < h; y++ {
+		// Centre of the sample in full resolution pixel coordinates
+		py := (float64(y) + 0.5) * float64(vsub) - 0.5
+		for x := 0; x < w; x++ {
+			px := (float64(x) + 0.5) * float64(hsub) - 0.5
+
+			xd, yd := c.Distortion.Distort((px - c.CX) / c.FocalX, (py - c.CY) / c.FocalY)
+
+			sx := (xd * c.FocalX + c.CX + 0.5) / float64(hsub) - 0.5
+			sy := (yd * c.FocalY + c.CY + 0.5) / float64(vsub) - 0.5
+
+			// Clamp rather than fill, so that we don't introduce edges
+			// around the border
+			ix := max(0, min(w - 1, int(math.Floor(sx + 0.5))))
+			iy := max(0, min(h - 1, int(math.Floor(sy + 0.5))))
+
+			table[y * w + x] = int32(iy * w + ix)
+		}
+	}
+
+	return table
+}
+
+func remapPlane(table []int32, w, h int, src []uint8, srcStride int, dst []uint8, dstStride int) {
+	for y := 0; y < h; y++ {
+		row := table[y * w : y * w + w]
+		out := dst[y * dstStride : y * dstStride + w]
+		for x, idx := range row {
+			sy, sx := int(idx) / w, int(idx) % w
+			out[x] = src[sy * srcStride + sx]
+		}
+	}
+}
+
+// Undistorts src into dst, which is allocated if nil. Returns nil if either
+// frame doesn't match the remap.
+func (r *Remap) UndistortGray(src, dst *image.Gray) *image.Gray {
+	w, h := ImageDims(src)
+	if w != r.Width || h != r.Height {
+		return nil
+	}
+
+	if dst == nil {
+		dst = image.NewGray(image.Rect(0, 0, w, h))
+	} else if dw, dh := ImageDims(dst); dw != w || dh != h {
+		return nil
+	}
+
+	remapPlane(r.luma, w, h, src.Pix[src.PixOffset(src.Rect.Min.X, src.Rect.Min.Y):], src.Stride,
+		dst.Pix[dst.PixOffset(dst.Rect.Min.X, dst.Rect.Min.Y):], dst.Stride)
+
+	return dst
+}
+
+// Undistorts src into dst, which is allocated if nil. Returns nil if either
+// frame doesn't match the remap.
+func (r *Remap) UndistortYCbCr(src, dst *image.YCbCr) *image.YCbCr {
+	w, h := ImageDims(src)
+	if w != r.Width || h != r.Height || src.SubsampleRatio != r.SubsampleRatio {
+		return nil
+	}
+
+	if dst == nil {
+		dst = image.NewYCbCr(image.Rect(0, 0, w, h), src.SubsampleRatio)
+	} else if dw, dh := ImageDims(dst); dw != w || dh != h || dst.SubsampleRatio != r.SubsampleRatio {
+		return nil
+	}
+
+	o := src.Rect.Min
+	remapPlane(r.luma, w, h, src.Y[src.YOffset(o.X, o.Y):], src.YStride,
+		dst.Y[dst.YOffset(dst.Rect.Min.X, dst.Rect.Min.Y):], dst.YStride)
+
+	scoff := src.COffset(o.X, o.Y)
+	dcoff := dst.COffset(dst.Rect.Min.X, dst.Rect.Min.Y)
+	remapPlane(r.chroma, r.cw, r.ch, src.Cb[scoff:], src.CStride, dst.Cb[dcoff:], dst.CStride)
+	remapPlane(r.chroma, r.cw, r.ch, src.Cr[scoff:], src.CStride, dst.Cr[dcoff:], dst.CStride)
+
+	return dst
+}
+
+// Undistorts *image.YCbCr and *image.Gray frames, to be used before
+// DeltaCByCol/DeltaCByRow and friends. Other image types are returned as-is.
+func (r *Remap) Undistort(in image.Image) image.Image {
+	switch v := in.(type) {
+	case *image.YCbCr:
+		if out := r.UndistortYCbCr(v, nil); out != nil {
+			return out
+		}
+	case *image.Gray:
+		if out := r.UndistortGray(v, nil); out != nil {
+			return out
+		}
+	}
+
+	return in
+}
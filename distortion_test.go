@@ -0,0 +1,163 @@
+package cv
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+// Mild barrel distortion, with a little tangential, like a cheap wide-angle
+// camera module
+var testDistortion = Distortion{ K1: -0.28, K2: 0.09, K3: -0.01, P1: 0.001, P2: -0.0015 }
+
+func TestDistortionRoundTrip(t *testing.T) {
+	d := testDistortion
+	for y := -0.6; y <= 0.6; y += 0.05 {
+		for x := -0.8; x <= 0.8; x += 0.05 {
+			xd, yd := d.Distort(x, y)
+			xu, yu := d.Undistort(xd, yd)
+			if math.Abs(xu - x) > 1e-6 || math.Abs(yu - y) > 1e-6 {
+				t.Errorf("(%.2f, %.2f) -> (%v, %v) -> (%v, %v)", x, y, xd, yd, xu, yu)
+			}
+		}
+	}
+
+	// No distortion leaves everything where it is
+	if x, y := (Distortion{}).Distort(0.3, -0.2); x != 0.3 || y != -0.2 {
+		t.Errorf("zero distortion moved (0.3, -0.2) to (%v, %v)", x, y)
+	}
+}
+
+func TestCameraPointRoundTrip(t *testing.T) {
+	c := NewCameraFOV(320, 240, 1.2)
+	c.Distortion = testDistortion
+
+	for _, p := range [][2]float32{ { 0.5, 0.5 }, { 0.1, 0.1 }, { 0.9, 0.3 }, { 0.25, 0.8 } } {
+		dx, dy := c.DistortPoint(p[0], p[1])
+		ux, uy := c.UndistortPoint(dx, dy)
+		if math.Abs(float64(ux - p[0])) > 1e-5 || math.Abs(float64(uy - p[1])) > 1e-5 {
+			t.Errorf("%v -> (%v, %v) -> (%v, %v)", p, dx, dy, ux, uy)
+		}
+	}
+
+	// The principal point doesn't move
+	if x, y := c.DistortPoint(0.5, 0.5); x != 0.5 || y != 0.5 {
+		t.Errorf("centre distorted to (%v, %v)", x, y)
+	}
+}
+
+func TestBuildRemapTable(t *testing.T) {
+	w, h := 64, 48
+	c := NewCameraFOV(w, h, 1.2)
+
+	// Without distortion every sample maps to itself, at both resolutions
+	for _, sub := range [][2]int{ { 1, 1 }, { 2, 2 }, { 2, 1 } } {
+		pw, ph := w / sub[0], h / sub[1]
+		for i, idx := range buildRemapTable(c, pw, ph, sub[0], sub[1]) {
+			if int(idx) != i {
+				t.Fatalf("subsampled %v: sample %d maps to %d", sub, i, idx)
+			}
+		}
+	}
+
+	// With it, each pixel comes from the nearest pixel to where the lens
+	// puts it, clamped to the frame. Pixel x is at normalised x / w, as for
+	// the detectors' results.
+	c.Distortion = testDistortion
+	table := buildRemapTable(c, w, h, 1, 1)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := c.DistortPoint(float32(x) / float32(w), float32(y) / float32(h))
+			sx := min(w - 1, max(0, int(math.Floor(float64(dx) * float64(w) + 0.5))))
+			sy := min(h - 1, max(0, int(math.Floor(float64(dy) * float64(h) + 0.5))))
+			if got := int(table[y * w + x]); got != sy * w + sx {
+				t.Errorf("(%d, %d) comes from (%d, %d), want (%d, %d)", x, y, got % w, got / w, sx, sy)
+			}
+		}
+	}
+}
+
+// A smooth ramp, so nearest-neighbour sampling is only ever out by a level
+// or two
+func rampValue(x, y float64, w, h int) uint8 {
+	return uint8(math.Max(0, math.Min(255, (x + y) * 255 / float64(w + h))))
+}
+
+func TestRemapUndistortsDistortedFrame(t *testing.T) {
+	w, h := 160, 120
+	c := NewCameraFOV(w, h, 1.2)
+	c.Distortion = testDistortion
+
+	// What the lens would see of the ramp
+	distorted := image.NewYCbCr(image.Rect(0, 0, w, h), image.YCbCrSubsampleRatio420)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			ux, uy := c.UndistortPoint(float32(x) / float32(w), float32(y) / float32(h))
+			v := rampValue(float64(ux) * float64(w), float64(uy) * float64(h), w, h)
+			distorted.Y[distorted.YOffset(x, y)] = v
+			distorted.Cb[distorted.COffset(x, y)] = v
+			distorted.Cr[distorted.COffset(x, y)] = 255 - v
+		}
+	}
+
+	r := NewRemap(c, image.YCbCrSubsampleRatio420)
+	out := r.UndistortYCbCr(distorted, nil)
+	if out == nil {
+		t.Fatalf("remap refused a matching frame")
+	}
+
+	// Away from the borders, where the lens had nothing to give
+	for y := h / 4; y < h * 3 / 4; y++ {
+		for x := w / 4; x < w * 3 / 4; x++ {
+			want := int(rampValue(float64(x), float64(y), w, h))
+			got := out.YCbCrAt(x, y)
+			if absInt(int(got.Y) - want) > 2 || absInt(int(got.Cb) - want) > 4 || absInt(int(got.Cr) - (255 - want)) > 4 {
+				t.Fatalf("(%d, %d) = %v, want around %d", x, y, got, want)
+			}
+		}
+	}
+
+	gray := r.UndistortGray(ToGray(distorted), nil)
+	if gray == nil {
+		t.Fatalf("remap refused a matching grey frame")
+	}
+	for y := h / 4; y < h * 3 / 4; y++ {
+		for x := w / 4; x < w * 3 / 4; x++ {
+			if gray.GrayAt(x, y).Y != out.YCbCrAt(x, y).Y {
+				t.Fatalf("grey and luma differ at (%d, %d)", x, y)
+			}
+		}
+	}
+}
+
+func TestRemapRejectsMismatchedFrames(t *testing.T) {
+	c := NewCameraFOV(64, 48, 1.2)
+	r := NewRemap(c, image.YCbCrSubsampleRatio420)
+
+	src := image.NewYCbCr(image.Rect(0, 0, 64, 48), image.YCbCrSubsampleRatio420)
+	for _, dst := range []*image.YCbCr{
+		image.NewYCbCr(image.Rect(0, 0, 32, 24), image.YCbCrSubsampleRatio420),
+		image.NewYCbCr(image.Rect(0, 0, 64, 48), image.YCbCrSubsampleRatio444),
+	} {
+		if r.UndistortYCbCr(src, dst) != nil {
+			t.Errorf("accepted a %v %v destination", dst.Bounds(), dst.SubsampleRatio)
+		}
+	}
+	if r.UndistortYCbCr(image.NewYCbCr(src.Rect, image.YCbCrSubsampleRatio422), nil) != nil {
+		t.Errorf("accepted a 4:2:2 source for a 4:2:0 remap")
+	}
+
+	graySrc := image.NewGray(image.Rect(0, 0, 64, 48))
+	if r.UndistortGray(graySrc, image.NewGray(image.Rect(0, 0, 63, 48))) != nil {
+		t.Errorf("accepted a grey destination of the wrong size")
+	}
+	if r.UndistortGray(image.NewGray(image.Rect(0, 0, 48, 64)), nil) != nil {
+		t.Errorf("accepted a grey source of the wrong size")
+	}
+
+	// An offset destination of the right size is fine
+	dst := image.NewGray(image.Rect(5, 7, 69, 55))
+	if r.UndistortGray(graySrc, dst) != dst {
+		t.Errorf("refused an offset destination")
+	}
+}
@@ -0,0 +1,205 @@
+package cv
+
+import (
+	"image"
+	"math"
+	"sync"
+)
+
+// The DeltaCByRow and DeltaCByCol family, walking the image a row at a time
+// and writing into a caller-supplied image so that nothing needs allocating
+// once the buffers are warm:
+//
+//	var rows, cols *image.Gray
+//	for {
+//		...
+//		rows = DeltaCByRowInto(rows, frame, frame.Bounds(), nil, nil)
+//		cols = DeltaCByColInto(cols, frame, frame.Bounds(), nil, nil)
+//	}
+//
+// On a multi-core CPU, passing a BandPool instead of nil splits the work
+// between its goroutines, still without allocating.
+
+// A set of goroutines which DeltaCByRowInto and DeltaCByColInto can split
+// their rows between. Make one per detector and keep it for as long as the
+// detector runs. A pool must only be used by one call at a time.
+type BandPool struct {
+	bands int
+	jobs chan deltaJob
+	wg sync.WaitGroup
+}
+
+type deltaJob struct {
+	dst *image.Gray
+	in image.Image
+	roi image.Rectangle
+	m ColorMetric
+	byCol bool
+	from, to int
+}
+
+// Starts bands goroutines, which run until Close is called
+func NewBandPool(bands int) *BandPool {
+	p := &BandPool{
+		bands: max(bands, 1),
+	}
+	p.jobs = make(chan deltaJob, p.bands)
+	for i := 0; i < p.bands; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *BandPool) work() {
+	for j := range p.jobs {
+		deltaRows(j.dst, j.in, j.roi, j.m, j.byCol, j.from, j.to)
+		p.wg.Done()
+	}
+}
+
+// Stops the goroutines. The pool can't be used afterwards.
+func (p *BandPool) Close() {
+	close(p.jobs)
+}
+
+// Returns dst resized to r, if its Pix has room, or otherwise a new image.
+// The pixels aren't cleared.
+func ReuseGray(dst *image.Gray, r image.Rectangle) *image.Gray {
+	n := r.Dx() * r.Dy()
+	if dst == nil || cap(dst.Pix) < n {
+		return image.NewGray(r)
+	}
+
+	dst.Pix = dst.Pix[:n]
+	dst.Stride = r.Dx()
+	dst.Rect = r
+	return dst
+}
+
+// The size of DeltaCByRowInto's output for roi of in. Images with subsampled
+// chroma give one pixel per chroma sample.
+func DeltaCByRowBounds(in image.Image, roi image.Rectangle) image.Rectangle {
+	hsub, vsub := 1, 1
+	if v, ok := yuvPlanesOf(in); ok {
+		hsub, vsub = v.hsub, v.vsub
+	}
+	return image.Rect(0, 0, max(roi.Dx() / hsub, 0), max(roi.Dy() / vsub - 1, 0))
+}
+
+// The size of DeltaCByColInto's output for roi of in
+func DeltaCByColBounds(in image.Image, roi image.Rectangle) image.Rectangle {
+	hsub, vsub := 1, 1
+	if v, ok := yuvPlanesOf(in); ok {
+		hsub, vsub = v.hsub, v.vsub
+	}
+	return image.Rect(0, 0, max(roi.Dx() / hsub - 1, 0), max(roi.Dy() / vsub, 0))
+}
+
+// DeltaCByRowROIMetric, writing into dst (see ReuseGray), which is returned.
+// If pool isn't nil, the rows are split into bands which its goroutines work
+// on in parallel.
+func DeltaCByRowInto(dst *image.Gray, in image.Image, roi image.Rectangle, m ColorMetric, pool *BandPool) *image.Gray {
+	dst = ReuseGray(dst, DeltaCByRowBounds(in, roi))
+	deltaInto(dst, in, roi, metricOrDefault(m), pool, false)
+	return dst
+}
+
+// DeltaCByColMetric over roi, writing into dst as DeltaCByRowInto
+func DeltaCByColInto(dst *image.Gray, in image.Image, roi image.Rectangle, m ColorMetric, pool *BandPool) *image.Gray {
+	dst = ReuseGray(dst, DeltaCByColBounds(in, roi))
+	deltaInto(dst, in, roi, metricOrDefault(m), pool, true)
+	return dst
+}
+
+// Fills dst with the difference between each pixel and the one below it (or
+// to its right, if byCol)
+func deltaInto(dst *image.Gray, in image.Image, roi image.Rectangle, m ColorMetric, pool *BandPool, byCol bool) {
+	rows := dst.Rect.Dy()
+	if pool == nil || pool.bands <= 1 || rows < 2 {
+		deltaRows(dst, in, roi, m, byCol, 0, rows)
+		return
+	}
+
+	bands := min(pool.bands, rows)
+	pool.wg.Add(bands)
+	for i := 0; i < bands; i++ {
+		pool.jobs <- deltaJob{
+			dst: dst, in: in, roi: roi, m: m, byCol: byCol,
+			from: rows * i / bands,
+			to: rows * (i + 1) / bands,
+		}
+	}
+	pool.wg.Wait()
+}
+
+// Rows from (inclusive) to to (exclusive) of deltaInto
+func deltaRows(dst *image.Gray, in image.Image, roi image.Rectangle, m ColorMetric, byCol bool, from, to int) {
+	if v, ok := yuvPlanesOf(in); ok {
+		dx, dy := 0, v.vsub
+		if byCol {
+			dx, dy = v.hsub, 0
+		}
+		for row := from; row < to; row++ {
+			out := dst.Pix[row * dst.Stride:][:dst.Rect.Dx()]
+			v.deltaRow(out, roi.Min.X, roi.Min.Y + row * v.vsub, dx, dy, m)
+		}
+		return
+	}
+
+	dx, dy := 0, 1
+	if byCol {
+		dx, dy = 1, 0
+	}
+
+	if p, ok := rgbPixelsOf(in); ok {
+		rm := rgbMetricOf(m)
+		for row := from; row < to; row++ {
+			out := dst.Pix[row * dst.Stride:][:dst.Rect.Dx()]
+			y := roi.Min.Y + row
+			for i := range out {
+				x := roi.Min.X + i
+				out[i] = p.delta(in, rm, x, y, x + dx, y + dy)
+			}
+		}
+		return
+	}
+
+	for row := from; row < to; row++ {
+		out := dst.Pix[row * dst.Stride:][:dst.Rect.Dx()]
+		y := roi.Min.Y + row
+		for i := range out {
+			x := roi.Min.X + i
+			out[i] = m.Delta(in.At(x, y), in.At(x + dx, y + dy))
+		}
+	}
+}
+
+// Compares len(out) chroma samples along the row from luma position (x, y)
+// with the ones offset by (dx, dy)
+func (v *yuvPlanes) deltaRow(out []uint8, x, y, dx, dy int, m ColorMetric) {
+	yoff, coff := v.yOffset(x, y), v.cOffset(x, y)
+	yoff2, coff2 := v.yOffset(x + dx, y + dy), v.cOffset(x + dx, y + dy)
+	ystep, cstep := v.hsub * v.yStep, v.cStep
+
+	// Avoid the interface call for the common case. This is the same as
+	// defaultMetric.DeltaYCbCr.
+	if m == DefaultMetric {
+		for i := range out {
+			dY := absdiff_uint8(v.y[yoff], v.y[yoff2])
+			dCb := absdiff_uint8(v.cb[coff], v.cb[coff2])
+			dCr := absdiff_uint8(v.cr[coff], v.cr[coff2])
+			out[i] = uint8(math.Sqrt(float64(dY * dY + dCb * dCb + dCr * dCr)))
+
+			yoff, yoff2 = yoff + ystep, yoff2 + ystep
+			coff, coff2 = coff + cstep, coff2 + cstep
+		}
+		return
+	}
+
+	for i := range out {
+		out[i] = m.DeltaYCbCr(v.at(yoff, coff), v.at(yoff2, coff2))
+
+		yoff, yoff2 = yoff + ystep, yoff2 + ystep
+		coff, coff2 = coff + cstep, coff2 + cstep
+	}
+}
@@ -0,0 +1,113 @@
+package cv
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestDeltaCIntoAllocs(t *testing.T) {
+	ycc, rgba, gray := benchFrames()
+	pool := NewBandPool(4)
+	defer pool.Close()
+
+	for _, img := range []image.Image{ ycc, rgba, gray } {
+		for _, p := range []*BandPool{ nil, pool } {
+			var rows, cols *image.Gray
+			rows = DeltaCByRowInto(rows, img, img.Bounds(), nil, p)
+			cols = DeltaCByColInto(cols, img, img.Bounds(), nil, p)
+
+			n := testing.AllocsPerRun(10, func() {
+				rows = DeltaCByRowInto(rows, img, img.Bounds(), nil, p)
+				cols = DeltaCByColInto(cols, img, img.Bounds(), nil, p)
+			})
+			if n != 0 {
+				t.Errorf("%T, pool %v: %v allocations per run", img, p != nil, n)
+			}
+		}
+	}
+}
+
+func TestDeltaCIntoBands(t *testing.T) {
+	ycc, _, _ := benchFrames()
+	roi := image.Rect(3, 5, 201, 117)
+
+	for _, bands := range []int{ 2, 3, 7, 1000 } {
+		pool := NewBandPool(bands)
+		rows := DeltaCByRowInto(nil, ycc, roi, nil, pool)
+		cols := DeltaCByColInto(nil, ycc, roi, nil, pool)
+		pool.Close()
+
+		wantRows := DeltaCByRowInto(nil, ycc, roi, nil, nil)
+		wantCols := DeltaCByColInto(nil, ycc, roi, nil, nil)
+		if string(rows.Pix) != string(wantRows.Pix) || string(cols.Pix) != string(wantCols.Pix) {
+			t.Errorf("%d bands: results differ from a single band", bands)
+		}
+	}
+}
+
+// Images without a fast path compare each pixel with its right-hand
+// neighbour, so give one column fewer than the image
+func TestDeltaCByColGenericBounds(t *testing.T) {
+	w, h := 7, 5
+	pal := color.Palette{ color.Black, color.White, color.NRGBA{ 0xc0, 0x20, 0x20, 0xff } }
+	img := image.NewPaletted(image.Rect(10, 20, 10 + w, 20 + h), pal)
+	for i := range img.Pix {
+		img.Pix[i] = uint8((i * 7) % len(pal))
+	}
+
+	cols := DeltaCByCol(img)
+	if got, want := cols.Bounds(), image.Rect(0, 0, w - 1, h); got != want {
+		t.Fatalf("DeltaCByCol bounds %v, want %v", got, want)
+	}
+	if got, want := DeltaCByColBounds(img, img.Bounds()), image.Rect(0, 0, w - 1, h); got != want {
+		t.Errorf("DeltaCByColBounds %v, want %v", got, want)
+	}
+	rows := DeltaCByRow(img)
+	if got, want := rows.Bounds(), image.Rect(0, 0, w, h - 1); got != want {
+		t.Fatalf("DeltaCByRow bounds %v, want %v", got, want)
+	}
+
+	min := img.Bounds().Min
+	for y := 0; y < h; y++ {
+		for x := 0; x < w - 1; x++ {
+			want := DeltaC(img.At(min.X + x, min.Y + y), img.At(min.X + x + 1, min.Y + y))
+			if got := cols.GrayAt(x, y).Y; got != want {
+				t.Errorf("col delta at (%d, %d) = %d, want %d", x, y, got, want)
+			}
+		}
+	}
+}
+
+func BenchmarkDeltaCByRowInto(b *testing.B) {
+	ycc, _, _ := benchFrames()
+	for _, bands := range []int{ 1, 4 } {
+		b.Run(fmt.Sprintf("bands=%d", bands), func(b *testing.B) {
+			pool := NewBandPool(bands)
+			defer pool.Close()
+
+			b.ReportAllocs()
+			var dst *image.Gray
+			for i := 0; i < b.N; i++ {
+				dst = DeltaCByRowInto(dst, ycc, ycc.Bounds(), nil, pool)
+			}
+		})
+	}
+}
+
+func BenchmarkDeltaCByColInto(b *testing.B) {
+	ycc, _, _ := benchFrames()
+	for _, bands := range []int{ 1, 4 } {
+		b.Run(fmt.Sprintf("bands=%d", bands), func(b *testing.B) {
+			pool := NewBandPool(bands)
+			defer pool.Close()
+
+			b.ReportAllocs()
+			var dst *image.Gray
+			for i := 0; i < b.N; i++ {
+				dst = DeltaCByColInto(dst, ycc, ycc.Bounds(), nil, pool)
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package cv
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Summary of a set of absolute errors, all normalised to the frame size
+type ErrorStats struct {
+	N int `json:"n"`
+	Mean float64 `json:"mean"`
+	Median float64 `json:"median"`
+	P90 float64 `json:"p90"`
+	Max float64 `json:"max"`
+}
+
+func newErrorStats(errs []float64) ErrorStats {
+	if len(errs) == 0 {
+		return ErrorStats{}
+	}
+
+	sorted := append([]float64(nil), errs...)
+	sort.Float64s(sorted)
+
+	sum := 0.0
+	for _, e := range sorted {
+		sum += e
+	}
+
+	n := len(sorted)
+	return ErrorStats{
+		N: n,
+		Mean: sum / float64(n),
+		Median: sorted[n / 2],
+		P90: sorted[min(n - 1, n * 9 / 10)],
+		Max: sorted[n - 1],
+	}
+}
+
+func (s ErrorStats) String() string {
+	if s.N == 0 {
+		return "no samples"
+	}
+	return fmt.Sprintf("n=%d mean=%.4f median=%.4f p90=%.4f max=%.4f", s.N, s.Mean, s.Median, s.P90, s.Max)
+}
+
+type FrameResult struct {
+	Name string `json:"name"`
+	// Sum of the frame's errors, with misses and false positives counting
+	// as 1 each
+	Error float64 `json:"error"`
+	// What went wrong, e.g. "missed board" or "left 0.052"
+	Problems []string `json:"problems"`
+}
+
+type EvalReport struct {
+	Frames int `json:"frames"`
+
+	// Board detections, counted against frames labelled with a board and
+	// frames labelled with no board
+	BoardTP int `json:"board_tp"`
+	BoardFN int `json:"board_fn"`
+	BoardFP int `json:"board_fp"`
+	BoardTN int `json:"board_tn"`
+	DetectionRate float64 `json:"detection_rate"`
+	FalsePositiveRate float64 `json:"false_positive_rate"`
+
+	// Only for frames where the board was labelled and found
+	Left ErrorStats `json:"left"`
+	Right ErrorStats `json:"right"`
+	Bottom ErrorStats `json:"bottom"`
+
+	ColorLabelled int `json:"color_labelled"`
+	ColorCorrect int `json:"color_correct"`
+
+	// FindHorizon against "horizon" labels, and FindHorizonLine against
+	// "horizon_line" labels. The line error is the larger of the errors at
+	// the left and right of the frame.
+	Horizon ErrorStats `json:"horizon"`
+	HorizonLine ErrorStats `json:"horizon_line"`
+	HorizonMissed int `json:"horizon_missed"`
+
+	// Highest Error first
+	Worst []FrameResult `json:"worst"`
+}
+
+func isNaN32(f float32) bool {
+	return math.IsNaN(float64(f))
+}
+
+func abs32(f float32) float64 {
+	return math.Abs(float64(f))
+}
+
+// Runs the detectors over every frame and scores them against the labels,
+// keeping the worst frames in the report
+func Evaluate(frames []LabelledFrame, palette Palette, opts *Options, worst int) *EvalReport {
+	opts = opts.orDefault()
+	r := &EvalReport{ Frames: len(frames) }
+
+	var left, right, bottom, horizon, line []float64
+	results := make([]FrameResult, 0, len(frames))
+
+	for _, f := range frames {
+		res := FrameResult{ Name: f.Label.File, Problems: []string{} }
+		if f.Name != "" {
+			res.Name = f.Name
+		}
+		problem := func(e float64, format string, args ...interface{}) {
+			res.Error += e
+			res.Problems = append(res.Problems, fmt.Sprintf(format, args...))
+		}
+		// Small errors are expected, so aren't worth listing
+		measure := func(errs *[]float64, name string, e float64) {
+			*errs = append(*errs, e)
+			res.Error += e
+			if e >= 0.02 {
+				res.Problems = append(res.Problems, fmt.Sprintf("%s %.3f", name, e))
+			}
+		}
+
+		l := f.Label
+		labelColor := ""
+		if l.Board != nil {
+			labelColor = l.Board.Color
+		}
+
+		// With a labelled colour the edges are scored on their own, apart
+		// from the colour classification. Otherwise the board is wherever
+		// ClassifyBoard finds it
+		var class ColorClassification
+		if palette != nil {
+			class = ClassifyBoard(f.Image, palette, opts)
+		}
+
+		var det BoardDetection
+		if i := palette.Find(labelColor); labelColor != "" && i >= 0 {
+			det = DetectBoard(f.Image, palette[i].Color, image.Rectangle{}, opts)
+		} else if palette != nil {
+			det = class.Board
+		} else {
+			det = DetectBoard(f.Image, nil, image.Rectangle{}, opts)
+		}
+		found := det.State != BoardNotFound
+
+		switch {
+		case l.Board != nil && found:
+			r.BoardTP++
+
+			measure(&left, "left", abs32(det.Left - l.Board.Left))
+			measure(&right, "right", abs32(det.Right - l.Board.Right))
+
+			if l.Board.Bottom != nil {
+				if isNaN32(det.Bottom) {
+					problem(1, "missed bottom")
+				} else {
+					measure(&bottom, "bottom", abs32(det.Bottom - *l.Board.Bottom))
+				}
+			}
+		case l.Board != nil:
+			r.BoardFN++
+			problem(1, "missed board")
+		case !l.NoBoard:
+			// Not labelled either way
+		case found:
+			r.BoardFP++
+			problem(1, "false positive board (%v)", det.State)
+		default:
+			r.BoardTN++
+		}
+
+		if labelColor != "" && palette != nil {
+			r.ColorLabelled++
+			if strings.EqualFold(class.Name, l.Board.Color) {
+				r.ColorCorrect++
+			} else {
+				problem(1, "colour %q, not %q", class.Name, l.Board.Color)
+			}
+		}
+
+		if l.Horizon != nil {
+			if h := FindHorizonOpts(f.Image, opts); isNaN32(h) {
+				r.HorizonMissed++
+				problem(1, "missed horizon")
+			} else {
+				measure(&horizon, "horizon", abs32(h - *l.Horizon))
+			}
+		}
+
+		if l.HorizonLine != nil {
+			if hl := FindHorizonLine(f.Image, opts); isNaN32(hl.Slope) {
+				r.HorizonMissed++
+				problem(1, "missed horizon line")
+			} else {
+				want := HorizonLine{ Slope: l.HorizonLine.Slope, Intercept: l.HorizonLine.Intercept }
+				e := math.Max(abs32(hl.At(0) - want.At(0)), abs32(hl.At(1) - want.At(1)))
+				measure(&line, "horizon line", e)
+			}
+		}
+
+		results = append(results, res)
+	}
+
+	r.Left = newErrorStats(left)
+	r.Right = newErrorStats(right)
+	r.Bottom = newErrorStats(bottom)
+	r.Horizon = newErrorStats(horizon)
+	r.HorizonLine = newErrorStats(line)
+
+	if n := r.BoardTP + r.BoardFN; n > 0 {
+		r.DetectionRate = float64(r.BoardTP) / float64(n)
+	}
+	if n := r.BoardFP + r.BoardTN; n > 0 {
+		r.FalsePositiveRate = float64(r.BoardFP) / float64(n)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Error > results[j].Error
+	})
+	r.Worst = results[:min(max(worst, 0), len(results))]
+
+	return r
+}
+
+func (r *EvalReport) WriteText(w io.Writer) error {
+	pct := func(n, d int) string {
+		if d == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f%%", 100 * float64(n) / float64(d))
+	}
+
+	lines := []string{
+		fmt.Sprintf("Frames:          %d", r.Frames),
+		fmt.Sprintf("Board detection: %s (%d/%d)", pct(r.BoardTP, r.BoardTP + r.BoardFN), r.BoardTP, r.BoardTP + r.BoardFN),
+		fmt.Sprintf("False positives: %s (%d/%d)", pct(r.BoardFP, r.BoardFP + r.BoardTN), r.BoardFP, r.BoardFP + r.BoardTN),
+		fmt.Sprintf("Left error:      %v", r.Left),
+		fmt.Sprintf("Right error:     %v", r.Right),
+		fmt.Sprintf("Bottom error:    %v", r.Bottom),
+		fmt.Sprintf("Colour:          %s (%d/%d)", pct(r.ColorCorrect, r.ColorLabelled), r.ColorCorrect, r.ColorLabelled),
+		fmt.Sprintf("Horizon error:   %v", r.Horizon),
+		fmt.Sprintf("Line error:      %v", r.HorizonLine),
+		fmt.Sprintf("Horizon missed:  %d", r.HorizonMissed),
+	}
+
+	if len(r.Worst) > 0 {
+		lines = append(lines, "Worst frames:")
+		for _, f := range r.Worst {
+			lines = append(lines, fmt.Sprintf("  %-24s %.4f %s", f.Name, f.Error, strings.Join(f.Problems, ", ")))
+		}
+	}
+
+	_, err := io.WriteString(w, strings.Join(lines, "\n") + "\n")
+	return err
+}
@@ -0,0 +1,52 @@
+package cv
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestEvaluateLabels(t *testing.T) {
+	s := DefaultScene()
+	withBoard, truth := s.Render()
+	s.Boards = nil
+	empty, _ := s.Render()
+
+	bt := truth.Boards[0]
+	board := &BoardLabel{ Left: bt.Left, Right: bt.Right, Bottom: &bt.Bottom, Color: "red" }
+	frames := []LabelledFrame{
+		{ Image: withBoard, Name: "board", Label: FrameLabel{ Board: board } },
+		{ Image: empty, Name: "no board", Label: FrameLabel{ NoBoard: true } },
+		// Neither of these is labelled, so the board shouldn't be scored
+		{ Image: withBoard, Name: "unlabelled board", Label: FrameLabel{} },
+		{ Image: empty, Name: "unlabelled empty", Label: FrameLabel{} },
+	}
+
+	r := Evaluate(frames, DefaultPalette(), nil, 0)
+	if r.BoardTP != 1 || r.BoardFN != 0 || r.BoardFP != 0 || r.BoardTN != 1 {
+		t.Errorf("TP %d FN %d FP %d TN %d, want 1 0 0 1", r.BoardTP, r.BoardFN, r.BoardFP, r.BoardTN)
+	}
+	if r.ColorLabelled != 1 || r.ColorCorrect != 1 {
+		t.Errorf("colour %d/%d, want 1/1", r.ColorCorrect, r.ColorLabelled)
+	}
+
+	// The bottom can only be found knowing the board colour
+	if r.Bottom.N != 1 || r.Bottom.Max > 0.02 {
+		t.Errorf("bottom error %v", r.Bottom)
+	}
+	if r.Left.Max > 0.02 || r.Right.Max > 0.02 {
+		t.Errorf("left error %v, right error %v", r.Left, r.Right)
+	}
+}
+
+func TestLoadDatasetBoardAndNoBoard(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "labels.json")
+	data := `{ "frames": [ { "file": "a.png", "board": { "left": 0.1, "right": 0.5 }, "no_board": true } ] }`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadDataset(path); err == nil {
+		t.Errorf("expected an error for a frame with both board and no_board")
+	}
+}
@@ -0,0 +1,110 @@
+package cv
+
+import (
+	"image/color"
+)
+
+// Integer-only versions of DeltaCYCbCr and DeltaCNRGBA, using lookup tables
+// in place of math.Sqrt. Nothing uses them: on amd64 they are no faster per
+// call, and DefaultMetric's inlined YCbCr fast path is slower with the tables
+// than with math.Sqrt on the same integer sum of squares. They are here for
+// CPUs without fast floating point, so run BenchmarkDeltaRowFixed in
+// fixedpoint_test.go on the target before wiring them in.
+//
+// Tolerance: the results are the exact floor of the square root of the
+// integer sum of squares. The float versions compute the same thing but can
+// round down to one less when the true result is a whole number, so the
+// fixed-point results are the same or 1 higher. DeltaCNRGBAFixed also
+// truncates the cubic term before the square root, which also only gives
+// the same or 1 higher. TestDeltaCFixedTolerance checks every combination
+// of differences. Results over 255 wrap, so "1 higher" than 255 is 0.
+
+// Squares of every 8-bit difference
+var sqTable [256]uint32
+
+// Floor of the square root of every value below 1 << 16
+var sqrtTable [1 << 16]uint8
+
+func init() {
+	for i := range sqTable {
+		sqTable[i] = uint32(i * i)
+	}
+
+	r := 0
+	for i := range sqrtTable {
+		if (r + 1) * (r + 1) <= i {
+			r++
+		}
+		sqrtTable[i] = uint8(r)
+	}
+}
+
+// Floor of the square root of n, bit by bit
+func isqrt(n uint32) uint32 {
+	var res uint32
+	bit := uint32(1) << 30
+	for bit > n {
+		bit >>= 2
+	}
+
+	for bit != 0 {
+		if n >= res + bit {
+			n -= res + bit
+			res = (res >> 1) + bit
+		} else {
+			res >>= 1
+		}
+		bit >>= 2
+	}
+
+	return res
+}
+
+// Floor of the square root of n. Sums of three squared differences are below
+// 1 << 20, so can be looked up from the top 16 bits and corrected by at most 3.
+func sqrtFixed(n uint32) uint32 {
+	switch {
+	case n < 1 << 16:
+		return uint32(sqrtTable[n])
+	case n < 1 << 18:
+		r := uint32(sqrtTable[n >> 2]) << 1
+		if (r + 1) * (r + 1) <= n {
+			r++
+		}
+		return r
+	case n < 1 << 20:
+		r := uint32(sqrtTable[n >> 4]) << 2
+		for (r + 1) * (r + 1) <= n {
+			r++
+		}
+		return r
+	}
+	return isqrt(n)
+}
+
+func sumSqYCbCr(a, b color.YCbCr) uint32 {
+	return sqTable[absdiff_uint8(a.Y, b.Y)] +
+		sqTable[absdiff_uint8(a.Cb, b.Cb)] +
+		sqTable[absdiff_uint8(a.Cr, b.Cr)]
+}
+
+// DeltaCYCbCr without floating point
+func DeltaCYCbCrFixed(a, b color.YCbCr) uint8 {
+	return uint8(sqrtFixed(sumSqYCbCr(a, b)))
+}
+
+func sumSqNRGBA(a, b color.NRGBA) uint32 {
+	dr := absdiff_uint8(a.R, b.R)
+	dg := absdiff_uint8(a.G, b.G)
+	db := absdiff_uint8(a.B, b.B)
+
+	sum := 2 * int(sqTable[dr]) + 4 * int(sqTable[dg]) + 3 * int(sqTable[db]) +
+		dr * (int(sqTable[dr]) - int(sqTable[db])) / 256
+
+	return uint32(sum)
+}
+
+// DeltaCNRGBA without floating point
+func DeltaCNRGBAFixed(a, b color.NRGBA) uint8 {
+	return uint8(sqrtFixed(sumSqNRGBA(a, b)))
+}
@@ -0,0 +1,199 @@
+package cv
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+// Every combination of three 8-bit differences, checked against the float
+// versions. The fixed-point results are compared before truncating to 8 bits,
+// as what a float64 over 255 turns into is up to the CPU.
+func TestDeltaCFixedTolerance(t *testing.T) {
+	zeroYCC, zeroRGB := color.YCbCr{}, color.NRGBA{ A: 255 }
+	bad := 0
+
+	for i := 0; i < 1 << 24; i++ {
+		d0, d1, d2 := uint8(i >> 16), uint8(i >> 8), uint8(i)
+
+		ycc := color.YCbCr{ d0, d1, d2 }
+		sum := sumSqYCbCr(ycc, zeroYCC)
+		fixed := int(sqrtFixed(sum))
+		if n := int(sum); fixed * fixed > n || (fixed + 1) * (fixed + 1) <= n {
+			t.Errorf("sqrtFixed(%d) = %d, not the floor of the square root", sum, fixed)
+			bad++
+		}
+		if diff := fixed - int(deltaYCbCr(ycc, zeroYCC)); diff < 0 || diff > 1 {
+			t.Errorf("YCbCr %v: fixed %d, float %v", ycc, fixed, deltaYCbCr(ycc, zeroYCC))
+			bad++
+		}
+		if uint8(fixed) != DeltaCYCbCrFixed(ycc, zeroYCC) {
+			t.Errorf("YCbCr %v: DeltaCYCbCrFixed doesn't wrap", ycc)
+			bad++
+		}
+
+		rgb := color.NRGBA{ d0, d1, d2, 255 }
+		fixed = int(sqrtFixed(sumSqNRGBA(rgb, zeroRGB)))
+		if diff := fixed - int(deltaNRGBA(rgb, zeroRGB)); diff < 0 || diff > 1 {
+			t.Errorf("NRGBA %v: fixed %d, float %v", rgb, fixed, deltaNRGBA(rgb, zeroRGB))
+			bad++
+		}
+
+		if bad > 20 {
+			t.Fatalf("too many failures")
+		}
+	}
+}
+
+// Every pair from a spread of colours, so that the tables aren't all in cache
+func benchYCbCrColors() []color.YCbCr {
+	cols := make([]color.YCbCr, 4096)
+	for i := range cols {
+		cols[i] = color.YCbCr{ uint8(i * 37), uint8(i * 91 + 7), uint8(i * 13 + 101) }
+	}
+	return cols
+}
+
+func benchNRGBAColors() []color.NRGBA {
+	cols := make([]color.NRGBA, 4096)
+	for i := range cols {
+		cols[i] = color.NRGBA{ uint8(i * 37), uint8(i * 91 + 7), uint8(i * 13 + 101), 255 }
+	}
+	return cols
+}
+
+var benchSink uint8
+
+func BenchmarkDeltaCYCbCr(b *testing.B) {
+	cols := benchYCbCrColors()
+	for i := 0; i < b.N; i++ {
+		benchSink += DeltaCYCbCr(cols[i & 4095], cols[(i + 1) & 4095])
+	}
+}
+
+func BenchmarkDeltaCYCbCrFixed(b *testing.B) {
+	cols := benchYCbCrColors()
+	for i := 0; i < b.N; i++ {
+		benchSink += DeltaCYCbCrFixed(cols[i & 4095], cols[(i + 1) & 4095])
+	}
+}
+
+func BenchmarkDeltaCNRGBA(b *testing.B) {
+	cols := benchNRGBAColors()
+	for i := 0; i < b.N; i++ {
+		benchSink += DeltaCNRGBA(cols[i & 4095], cols[(i + 1) & 4095])
+	}
+}
+
+func BenchmarkDeltaCNRGBAFixed(b *testing.B) {
+	cols := benchNRGBAColors()
+	for i := 0; i < b.N; i++ {
+		benchSink += DeltaCNRGBAFixed(cols[i & 4095], cols[(i + 1) & 4095])
+	}
+}
+
+// yuvPlanes.deltaRow's DefaultMetric loop as it would be with the tables, for
+// BenchmarkDeltaRowFixed to compare with the real one
+func (v *yuvPlanes) deltaRowFixed(out []uint8, x, y, dx, dy int) {
+	yoff, coff := v.yOffset(x, y), v.cOffset(x, y)
+	yoff2, coff2 := v.yOffset(x + dx, y + dy), v.cOffset(x + dx, y + dy)
+	ystep, cstep := v.hsub * v.yStep, v.cStep
+
+	for i := range out {
+		sum := sqTable[absdiff_uint8(v.y[yoff], v.y[yoff2])] +
+			sqTable[absdiff_uint8(v.cb[coff], v.cb[coff2])] +
+			sqTable[absdiff_uint8(v.cr[coff], v.cr[coff2])]
+		out[i] = uint8(sqrtFixed(sum))
+
+		yoff, yoff2 = yoff + ystep, yoff2 + ystep
+		coff, coff2 = coff + cstep, coff2 + cstep
+	}
+}
+
+// The default scene, as the camera, the simulator and PNG test data would
+// give it
+func benchFrames() (ycc *image.YCbCr, rgba *image.RGBA, gray *image.Gray) {
+	ycc, _ = DefaultScene().Render()
+	rgba = image.NewRGBA(ycc.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), ycc, ycc.Bounds().Min, draw.Src)
+	gray = image.NewGray(ycc.Bounds())
+	draw.Draw(gray, gray.Bounds(), ycc, ycc.Bounds().Min, draw.Src)
+	return ycc, rgba, gray
+}
+
+// Both inlined loops over a whole frame, by row and by column, with nothing
+// else in the way. The tables are only worth wiring back in to deltaRow if
+// "fixed" beats "float" on the target.
+func BenchmarkDeltaRowFixed(b *testing.B) {
+	ycc, _, _ := benchFrames()
+	v, _ := yuvPlanesOf(ycc)
+	w, h := ImageDims(ycc)
+	out := make([]uint8, w / v.hsub)
+
+	// The same sums of squares must give the same results
+	want := make([]uint8, len(out))
+	for y := 0; y < h - v.vsub; y += v.vsub {
+		v.deltaRow(want, 0, y, 0, v.vsub, DefaultMetric)
+		v.deltaRowFixed(out, 0, y, 0, v.vsub)
+		for i := range out {
+			if out[i] != want[i] {
+				b.Fatalf("(%d, %d): fixed %d, float %d", i, y, out[i], want[i])
+			}
+		}
+	}
+
+	for _, dir := range []struct {
+		name string
+		dx, dy int
+	}{
+		{ "row", 0, v.vsub },
+		{ "col", v.hsub, 0 },
+	} {
+		rows := (h - dir.dy) / v.vsub
+		n := (w - dir.dx) / v.hsub
+		b.Run(dir.name + "/float", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for r := 0; r < rows; r++ {
+					v.deltaRow(out[:n], 0, r * v.vsub, dir.dx, dir.dy, DefaultMetric)
+				}
+			}
+		})
+		b.Run(dir.name + "/fixed", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for r := 0; r < rows; r++ {
+					v.deltaRowFixed(out[:n], 0, r * v.vsub, dir.dx, dir.dy)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDeltaCByRow(b *testing.B) {
+	ycc, rgba, gray := benchFrames()
+	cases := []struct {
+		name string
+		img image.Image
+	}{
+		{ "ycbcr", ycc },
+		{ "rgba", rgba },
+		{ "gray", gray },
+	}
+
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				DeltaCByRowMetric(c.img, DefaultMetric)
+			}
+		})
+	}
+}
+
+func BenchmarkDeltaCByCol(b *testing.B) {
+	ycc, _, _ := benchFrames()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		DeltaCByColMetric(ycc, DefaultMetric)
+	}
+}
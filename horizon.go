@@ -2,39 +2,57 @@
 package cv
 
 import (
-	"fmt"
 	"image"
 	"math"
+	"math/rand"
 )
 
 func FindHorizon(img image.Image) float32 {
-	diff := DeltaCByRow(img)
+	return FindHorizonOpts(img, nil)
+}
+
+// opts may be nil, to use DefaultOptions
+func FindHorizonOpts(img image.Image, opts *Options) float32 {
+	opts = opts.orDefault()
+
+	diff := DeltaCByRowMetric(img, opts.metric())
+	opts.trace("horizon.row_delta", diff)
 	minMax := MinMaxColwise(diff)
 	ExpandContrastColWise(diff, minMax)
-	Threshold(diff, 128)
+	opts.trace("horizon.row_delta.expanded", diff)
+	opts.EdgeThreshold.Apply(diff)
+	opts.trace("horizon.row_delta.threshold", diff)
 
-	summed := FindHorizontalLines(diff)
+	summed := FindHorizontalLinesStripes(diff, opts.LineStripes)
+	opts.trace("horizon.projection", summed)
 	minMax = MinMaxColwise(summed)
 	ExpandContrastColWise(summed, minMax)
-	Threshold(summed, 128)
+	opts.trace("horizon.projection.expanded", summed)
+	opts.LineThreshold.Apply(summed)
+	opts.trace("horizon.projection.threshold", summed)
 
 	blobs := FindBlobs(summed.Pix)
+	opts.trace("horizon.blobs", blobs)
 	scale := img.Bounds().Dy() / len(summed.Pix)
 
 	if len(blobs) == 0 {
 		return float32(math.NaN())
 	}
 
+	y0 := img.Bounds().Min.Y
 	avgs := make([]uint8, 0, len(blobs))
 	for _, b := range blobs {
-		avgs = append(avgs, AverageDeltaC(img, b.First * scale, b.Second * scale))
+		avgs = append(avgs, AverageDeltaCMetric(img, y0 + b.First * scale, y0 + b.Second * scale, opts.metric()))
 	}
 	meanAvg := Mean(avgs)
+	opts.trace("horizon.avgs", avgs)
+	opts.trace("horizon.mean_avg", meanAvg)
 
 	for i := len(avgs) - 1; i >= 0; i-- {
 		a := avgs[i]
 		b := blobs[i]
 		if a >= meanAvg {
+			opts.trace("horizon.chosen", i)
 			return float32((b.First + b.Second + 1) / 2) / float32(len(summed.Pix))
 		}
 	}
@@ -43,17 +61,31 @@ func FindHorizon(img image.Image) float32 {
 }
 
 func FindHorizonROI(img image.Image, roi image.Rectangle) float32 {
-	diff := DeltaCByRowROI(img, roi)
+	return FindHorizonROIOpts(img, roi, nil)
+}
+
+// opts may be nil, to use DefaultOptions
+func FindHorizonROIOpts(img image.Image, roi image.Rectangle, opts *Options) float32 {
+	opts = opts.orDefault()
+
+	diff := DeltaCByRowROIMetric(img, roi, opts.metric())
+	opts.trace("horizon.row_delta", diff)
 	minMax := MinMaxColwise(diff)
 	ExpandContrastColWise(diff, minMax)
-	Threshold(diff, 128)
+	opts.trace("horizon.row_delta.expanded", diff)
+	opts.EdgeThreshold.Apply(diff)
+	opts.trace("horizon.row_delta.threshold", diff)
 
-	summed := FindHorizontalLines(diff)
+	summed := FindHorizontalLinesStripes(diff, opts.LineStripes)
+	opts.trace("horizon.projection", summed)
 	minMax = MinMaxColwise(summed)
 	ExpandContrastColWise(summed, minMax)
-	Threshold(summed, 128)
+	opts.trace("horizon.projection.expanded", summed)
+	opts.LineThreshold.Apply(summed)
+	opts.trace("horizon.projection.threshold", summed)
 
 	blobs := FindBlobs(summed.Pix)
+	opts.trace("horizon.blobs", blobs)
 	scale := roi.Dy() / len(summed.Pix)
 
 	if len(blobs) == 0 {
@@ -62,19 +94,155 @@ func FindHorizonROI(img image.Image, roi image.Rectangle) float32 {
 
 	avgs := make([]uint8, 0, len(blobs))
 	for _, b := range blobs {
-		avgs = append(avgs, AverageDeltaCROI(img, b.First * scale, b.Second * scale, roi))
+		avgs = append(avgs, AverageDeltaCROIMetric(img, roi.Min.Y + b.First * scale, roi.Min.Y + b.Second * scale, roi, opts.metric()))
 	}
 	meanAvg := Mean(avgs)
-
-	fmt.Println(meanAvg, avgs)
+	opts.trace("horizon.avgs", avgs)
+	opts.trace("horizon.mean_avg", meanAvg)
 
 	for i := len(avgs) - 1; i >= 0; i-- {
 		a := avgs[i]
 		b := blobs[i]
 		if a >= meanAvg {
+			opts.trace("horizon.chosen", i)
 			return float32((b.First + b.Second + 1) / 2) / float32(len(summed.Pix))
 		}
 	}
 
 	return float32(math.NaN())
 }
+
+// The horizon as a line, y = Slope * x + Intercept, in normalised image
+// coordinates. Everything is NaN if it couldn't be found.
+type HorizonLine struct {
+	Slope, Intercept float32
+	// Clockwise from horizontal, in radians, allowing for non-square images
+	Angle float32
+	// Proportion of the edge points which lie on the line
+	InlierRatio float32
+}
+
+// Normalised row of the line at normalised column x
+func (l HorizonLine) At(x float32) float32 {
+	return l.Slope * x + l.Intercept
+}
+
+// Returns the top of each vertical run of edge pixels in img, i.e. where the
+// colour changes going down each column
+func edgePoints(img *image.Gray) []Point2 {
+	w, h := ImageDims(img)
+	pts := []Point2{}
+
+	for x := 0; x < w; x++ {
+		prev := uint8(0)
+		for y := 0; y < h; y++ {
+			pix := img.Pix[y * img.Stride + x]
+			if pix > 0 && prev == 0 {
+				pts = append(pts, Point2{ float64(x), float64(y) })
+			}
+			prev = pix
+		}
+	}
+
+	return pts
+}
+
+// Fits y = m * x + c through pts by least squares
+func fitLine(pts []Point2) (m, c float64, ok bool) {
+	var sx, sy, sxx, sxy float64
+	for _, p := range pts {
+		sx += p.X
+		sy += p.Y
+		sxx += p.X * p.X
+		sxy += p.X * p.Y
+	}
+	n := float64(len(pts))
+
+	den := n * sxx - sx * sx
+	if n < 2 || den == 0 {
+		return 0, 0, false
+	}
+
+	m = (n * sxy - sx * sy) / den
+	c = (sy - m * sx) / n
+	return m, c, true
+}
+
+func lineInliers(pts []Point2, m, c, maxDist float64) []Point2 {
+	norm := math.Sqrt(1 + m * m)
+	in := []Point2{}
+	for _, p := range pts {
+		if math.Abs(m * p.X - p.Y + c) / norm <= maxDist {
+			in = append(in, p)
+		}
+	}
+	return in
+}
+
+// Finds the horizon as a straight line, which may be tilted if the robot
+// isn't level. The line is found by RANSAC over the DeltaCByRow edges, so
+// is the one which the most edge points agree on.
+//
+// opts may be nil, to use DefaultOptions
+func FindHorizonLine(img image.Image, opts *Options) HorizonLine {
+	opts = opts.orDefault()
+	nan := float32(math.NaN())
+	ret := HorizonLine{ nan, nan, nan, nan }
+
+	diff := DeltaCByRowMetric(img, opts.metric())
+	opts.trace("horizon_line.row_delta", diff)
+	minMax := MinMaxColwise(diff)
+	ExpandContrastColWise(diff, minMax)
+	opts.trace("horizon_line.row_delta.expanded", diff)
+	opts.EdgeThreshold.Apply(diff)
+	opts.trace("horizon_line.row_delta.threshold", diff)
+
+	pts := edgePoints(diff)
+	opts.trace("horizon_line.points", pts)
+	if len(pts) < 2 {
+		return ret
+	}
+
+	// Fixed seed, so that the same frame always gives the same answer
+	rnd := rand.New(rand.NewSource(1))
+
+	var best []Point2
+	for i := 0; i < opts.HorizonIterations; i++ {
+		a, b := pts[rnd.Intn(len(pts))], pts[rnd.Intn(len(pts))]
+		if a.X == b.X {
+			continue
+		}
+
+		m := (b.Y - a.Y) / (b.X - a.X)
+		if math.Abs(m) > opts.HorizonMaxSlope {
+			continue
+		}
+		c := a.Y - m * a.X
+
+		in := lineInliers(pts, m, c, opts.HorizonInlierDist)
+		if len(in) > len(best) {
+			best = in
+		}
+	}
+
+	m, c, ok := fitLine(best)
+	if !ok {
+		return ret
+	}
+	best = lineInliers(pts, m, c, opts.HorizonInlierDist)
+	opts.trace("horizon_line.inliers", best)
+
+	// The edge between rows y and y + 1 is stored at y
+	w, h := ImageDims(diff)
+	nw, nh := float64(w), float64(h + 1)
+	c += 1
+
+	ret.Slope = float32(m * nw / nh)
+	ret.Intercept = float32(c / nh)
+	ret.InlierRatio = float32(len(best)) / float32(len(pts))
+
+	iw, ih := ImageDims(img)
+	ret.Angle = float32(math.Atan(float64(ret.Slope) * float64(ih) / float64(iw)))
+
+	return ret
+}
@@ -0,0 +1,201 @@
+package cv
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Hand-labelled frames, for scoring the detectors. Everything is normalised
+// to the frame size, the same as the detectors' results.
+//
+// A dataset is a JSON file like:
+//
+//	{
+//		"source": "run1.y4m",
+//		"frames": [
+//			{ "seq": 12, "board": { "left": 0.3, "right": 0.62, "bottom": 0.7, "color": "red" }, "horizon": 0.75 },
+//			{ "seq": 30, "no_board": true },
+//			{ "file": "img/0040.png", "horizon_line": { "slope": 0.05, "intercept": 0.71 } }
+//		]
+//	}
+//
+// Frames either name an image file, or give the sequence number of a frame in
+// source (a Y4M file or a directory). Paths are relative to the dataset file.
+// Frames with neither "board" nor "no_board" (like the last one) aren't scored
+// on the board at all.
+
+type BoardLabel struct {
+	// Edges which are out of view are labelled at 0 or 1
+	Left float32 `json:"left"`
+	Right float32 `json:"right"`
+	// Omitted if the bottom isn't in view, or wasn't labelled
+	Bottom *float32 `json:"bottom,omitempty"`
+	// Palette colour name, if labelled
+	Color string `json:"color,omitempty"`
+}
+
+type HorizonLineLabel struct {
+	Slope float32 `json:"slope"`
+	Intercept float32 `json:"intercept"`
+}
+
+type FrameLabel struct {
+	File string `json:"file,omitempty"`
+	Seq int `json:"seq"`
+
+	// nil if there's no board in view, or the board wasn't labelled
+	Board *BoardLabel `json:"board,omitempty"`
+	// Set if the frame was labelled as having no board in view
+	NoBoard bool `json:"no_board,omitempty"`
+
+	// Either or neither of these may be labelled
+	Horizon *float32 `json:"horizon,omitempty"`
+	HorizonLine *HorizonLineLabel `json:"horizon_line,omitempty"`
+}
+
+type Dataset struct {
+	Source string `json:"source,omitempty"`
+	Frames []FrameLabel `json:"frames"`
+
+	// Directory paths are relative to
+	dir string
+}
+
+type LabelledFrame struct {
+	Image image.Image
+	Label FrameLabel
+	// File name, or source and sequence number, for reporting
+	Name string
+}
+
+func LoadDataset(path string) (*Dataset, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ds := &Dataset{}
+	if err := json.Unmarshal(data, ds); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	ds.dir = filepath.Dir(path)
+
+	for i, l := range ds.Frames {
+		if l.Board != nil && l.NoBoard {
+			return nil, fmt.Errorf("%s: frame %d has both board and no_board", path, i)
+		}
+	}
+
+	return ds, nil
+}
+
+func SaveDataset(path string, ds *Dataset) error {
+	data, err := json.MarshalIndent(ds, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func (ds *Dataset) path(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(ds.dir, p)
+}
+
+func loadImage(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return img, nil
+}
+
+// Loads the image for every labelled frame, in the same order as Frames
+func (ds *Dataset) Load() ([]LabelledFrame, error) {
+	frames := make([]LabelledFrame, len(ds.Frames))
+
+	// Frame index for each sequence number wanted from Source
+	fromSource := map[int][]int{}
+
+	for i, l := range ds.Frames {
+		frames[i].Label = l
+		if l.File == "" {
+			fromSource[l.Seq] = append(fromSource[l.Seq], i)
+			continue
+		}
+
+		img, err := loadImage(ds.path(l.File))
+		if err != nil {
+			return nil, err
+		}
+		frames[i].Image = img
+		frames[i].Name = l.File
+	}
+
+	if len(fromSource) == 0 {
+		return frames, nil
+	}
+	if ds.Source == "" {
+		return nil, fmt.Errorf("frames without a file need a source")
+	}
+
+	src, err := ds.openSource()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	// Frames which can't be read are skipped, in case they aren't ones
+	// we're after. If they were, the last error is the likely reason.
+	var skipped error
+	for len(fromSource) > 0 {
+		f, err := src.Next()
+		if err == io.EOF {
+			for seq := range fromSource {
+				if skipped != nil {
+					return nil, fmt.Errorf("%s: no frame %d (%v)", ds.Source, seq, skipped)
+				}
+				return nil, fmt.Errorf("%s: no frame %d", ds.Source, seq)
+			}
+		} else if err != nil {
+			skipped = err
+			continue
+		}
+
+		for _, i := range fromSource[f.Seq] {
+			frames[i].Image = f.Image
+			frames[i].Name = fmt.Sprintf("%s#%d", ds.Source, f.Seq)
+		}
+		delete(fromSource, f.Seq)
+	}
+
+	return frames, nil
+}
+
+func (ds *Dataset) openSource() (FrameSource, error) {
+	path := ds.path(ds.Source)
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return NewDirSource(path, 0)
+	}
+
+	return NewY4MSource(path)
+}
@@ -0,0 +1,286 @@
+package cv
+
+import (
+	"math"
+)
+
+// Just enough linear algebra for calibration. Matrices are row-major slices
+// of rows.
+
+func newMatrix(rows, cols int) [][]float64 {
+	m := make([][]float64, rows)
+	backing := make([]float64, rows * cols)
+	for i := range m {
+		m[i] = backing[i * cols : (i + 1) * cols]
+	}
+	return m
+}
+
+// Solves a.x = b by Gaussian elimination with partial pivoting. a and b are
+// left untouched. Returns false if a is singular.
+func solveLinear(a [][]float64, b []float64) ([]float64, bool) {
+	n := len(b)
+	m := newMatrix(n, n + 1)
+	for i := 0; i < n; i++ {
+		copy(m[i], a[i])
+		m[i][n] = b[i]
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(m[row][col]) > math.Abs(m[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(m[pivot][col]) < 1e-300 {
+			return nil, false
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+
+		for row := col + 1; row < n; row++ {
+			f := m[row][col] / m[col][col]
+			if f == 0 {
+				continue
+			}
+			for k := col; k <= n; k++ {
+				m[row][k] -= f * m[col][k]
+			}
+		}
+	}
+
+	x := make([]float64, n)
+	for row := n - 1; row >= 0; row-- {
+		sum := m[row][n]
+		for k := row + 1; k < n; k++ {
+			sum -= m[row][k] * x[k]
+		}
+		x[row] = sum / m[row][row]
+	}
+
+	return x, true
+}
+
+// Eigen-decomposition of the symmetric matrix a by Jacobi rotations. Returns
+// the eigenvalues, and the eigenvectors as the columns of vecs.
+func symEigen(a [][]float64) (vals []float64, vecs [][]float64) {
+	n := len(a)
+	m := newMatrix(n, n)
+	vecs = newMatrix(n, n)
+	for i := 0; i < n; i++ {
+		copy(m[i], a[i])
+		vecs[i][i] = 1
+	}
+
+	for sweep := 0; sweep < 100; sweep++ {
+		off := 0.0
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n; j++ {
+				off += m[i][j] * m[i][j]
+			}
+		}
+		if off < 1e-30 {
+			break
+		}
+
+		for p := 0; p < n; p++ {
+			for q := p + 1; q < n; q++ {
+				if math.Abs(m[p][q]) < 1e-300 {
+					continue
+				}
+
+				theta := (m[q][q] - m[p][p]) / (2 * m[p][q])
+				t := 1 / (math.Abs(theta) + math.Sqrt(theta * theta + 1))
+				if theta < 0 {
+					t = -t
+				}
+				c := 1 / math.Sqrt(t * t + 1)
+				s := t * c
+
+				for k := 0; k < n; k++ {
+					mkp, mkq := m[k][p], m[k][q]
+					m[k][p] = c * mkp - s * mkq
+					m[k][q] = s * mkp + c * mkq
+				}
+				for k := 0; k < n; k++ {
+					mpk, mqk := m[p][k], m[q][k]
+					m[p][k] = c * mpk - s * mqk
+					m[q][k] = s * mpk + c * mqk
+				}
+				for k := 0; k < n; k++ {
+					vkp, vkq := vecs[k][p], vecs[k][q]
+					vecs[k][p] = c * vkp - s * vkq
+					vecs[k][q] = s * vkp + c * vkq
+				}
+			}
+		}
+	}
+
+	vals = make([]float64, n)
+	for i := range vals {
+		vals[i] = m[i][i]
+	}
+
+	return vals, vecs
+}
+
+// Eigenvector of the symmetric matrix a with the smallest eigenvalue
+func smallestEigenvector(a [][]float64) []float64 {
+	vals, vecs := symEigen(a)
+
+	idx := 0
+	for i, v := range vals {
+		if v < vals[idx] {
+			idx = i
+		}
+	}
+
+	ret := make([]float64, len(a))
+	for i := range ret {
+		ret[i] = vecs[i][idx]
+	}
+	return ret
+}
+
+// Returns a^T.a
+func gram(a [][]float64) [][]float64 {
+	n := len(a[0])
+	out := newMatrix(n, n)
+	for _, row := range a {
+		for i := 0; i < n; i++ {
+			if row[i] == 0 {
+				continue
+			}
+			for j := i; j < n; j++ {
+				out[i][j] += row[i] * row[j]
+			}
+		}
+	}
+	for i := 0; i < n; i++ {
+		for j := 0; j < i; j++ {
+			out[i][j] = out[j][i]
+		}
+	}
+	return out
+}
+
+type mat3 [3][3]float64
+type vec3 [3]float64
+
+func (a mat3) mul(b mat3) mat3 {
+	var out mat3
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				out[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+	return out
+}
+
+func (a mat3) mulVec(v vec3) vec3 {
+	var out vec3
+	for i := 0; i < 3; i++ {
+		out[i] = a[i][0] * v[0] + a[i][1] * v[1] + a[i][2] * v[2]
+	}
+	return out
+}
+
+func (a mat3) col(i int) vec3 {
+	return vec3{ a[0][i], a[1][i], a[2][i] }
+}
+
+func (v vec3) norm() float64 {
+	return math.Sqrt(v.dot(v))
+}
+
+func (v vec3) dot(o vec3) float64 {
+	return v[0] * o[0] + v[1] * o[1] + v[2] * o[2]
+}
+
+func (v vec3) scale(s float64) vec3 {
+	return vec3{ v[0] * s, v[1] * s, v[2] * s }
+}
+
+func (v vec3) sub(o vec3) vec3 {
+	return vec3{ v[0] - o[0], v[1] - o[1], v[2] - o[2] }
+}
+
+func (v vec3) cross(o vec3) vec3 {
+	return vec3{
+		v[1] * o[2] - v[2] * o[1],
+		v[2] * o[0] - v[0] * o[2],
+		v[0] * o[1] - v[1] * o[0],
+	}
+}
+
+// Rotation matrix from a Rodrigues (axis * angle) vector
+func rodrigues(r vec3) mat3 {
+	theta := r.norm()
+	if theta < 1e-12 {
+		return mat3{
+			{ 1, -r[2], r[1] },
+			{ r[2], 1, -r[0] },
+			{ -r[1], r[0], 1 },
+		}
+	}
+
+	k := r.scale(1 / theta)
+	c, s := math.Cos(theta), math.Sin(theta)
+	var out mat3
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			out[i][j] = (1 - c) * k[i] * k[j]
+		}
+		out[i][i] += c
+	}
+	out[0][1] -= s * k[2]
+	out[0][2] += s * k[1]
+	out[1][0] += s * k[2]
+	out[1][2] -= s * k[0]
+	out[2][0] -= s * k[1]
+	out[2][1] += s * k[0]
+
+	return out
+}
+
+// The inverse of rodrigues, for a proper rotation matrix
+func rodriguesFromMatrix(m mat3) vec3 {
+	cos := math.Max(-1, math.Min(1, (m[0][0] + m[1][1] + m[2][2] - 1) / 2))
+	theta := math.Acos(cos)
+	axis := vec3{ m[2][1] - m[1][2], m[0][2] - m[2][0], m[1][0] - m[0][1] }
+
+	if theta < 1e-6 {
+		return axis.scale(0.5)
+	}
+
+	if math.Pi - theta > 1e-3 {
+		return axis.scale(theta / (2 * math.Sin(theta)))
+	}
+
+	// Near pi the antisymmetric part vanishes, so pull the axis out of the
+	// diagonal instead, and get the relative signs from the symmetric part
+	var k vec3
+	for i := 0; i < 3; i++ {
+		k[i] = math.Sqrt(math.Max(0, (m[i][i] + 1) / 2))
+	}
+	big := 0
+	for i := 1; i < 3; i++ {
+		if k[i] > k[big] {
+			big = i
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if i != big && m[big][i] + m[i][big] < 0 {
+			k[i] = -k[i]
+		}
+	}
+
+	// What's left of the antisymmetric part still says which way round
+	if k.dot(axis) < 0 {
+		k = k.scale(-1)
+	}
+
+	return k.scale(theta / k.norm())
+}
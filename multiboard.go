@@ -0,0 +1,136 @@
+package cv
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+type BoardSegment struct {
+	BoardDetection
+
+	// Mean colour of the segment, over the rows used for classification
+	Color color.Color
+
+	// Index and name of the closest palette colour, -1 and empty if there
+	// was no palette
+	Index int
+	Name string
+}
+
+// Finds every board across the width of the frame, from left to right.
+//
+// If palette is non-nil, only segments matching one of its colours are
+// returned. Otherwise every uniformly coloured segment is, which will include
+// any bits of wall between the boards.
+//
+// opts may be nil, to use DefaultOptions
+func FindBoards(in image.Image, palette Palette, opts *Options) []BoardSegment {
+	opts = opts.orDefault()
+	w, h := ImageDims(in)
+
+	// Find and amplify edges
+	diff := DeltaCByColMetric(in, opts.metric())
+	opts.trace("boards.col_delta", diff)
+	minMax := MinMaxRowwise(diff)
+	ExpandContrastRowWise(diff, minMax)
+	opts.trace("boards.col_delta.expanded", diff)
+	opts.EdgeThreshold.Apply(diff)
+	opts.trace("boards.col_delta.threshold", diff)
+
+	scale := w / diff.Bounds().Dx()
+
+	// Any row with a sensible number of edges might be crossing boards
+	blobs, strengths := findVerticalEdges(diff, 1, opts.MaxRowEdgesMulti, "boards.edges", opts)
+
+	// Split the frame up at each edge. The frame boundaries are "edges" too,
+	// but with no blob behind them
+	xs := []int{ 0 }
+	edgeBlobs := []int{ -1 }
+	edgeStrengths := []uint8{ 0 }
+	for i, b := range blobs {
+		x := min(w, RoundUp((b.First + b.Second) * scale / 2, opts.EdgeAlign))
+		if x <= xs[len(xs) - 1] {
+			continue
+		}
+		xs = append(xs, x)
+		edgeBlobs = append(edgeBlobs, i)
+		edgeStrengths = append(edgeStrengths, strengths[i])
+	}
+	if xs[len(xs) - 1] < w {
+		xs = append(xs, w)
+		edgeBlobs = append(edgeBlobs, -1)
+		edgeStrengths = append(edgeStrengths, 0)
+	}
+	opts.trace("boards.splits", xs)
+
+	minWidth := max(2, w / 32)
+	rows := boardSampleRows(h)
+
+	segs := make([]BoardSegment, 0, len(xs) - 1)
+	for i := 0; i < len(xs) - 1; i++ {
+		x0, x1 := xs[i], xs[i + 1]
+		if x1 - x0 < minWidth {
+			continue
+		}
+
+		c := MeanColorROI(in, image.Rect(x0, rows[0], x1, rows[len(rows) - 1] + 1))
+
+		// Boards are a flat colour, so anything too varied is something else
+		roi := image.Rect(x0, 0, x1, h)
+		total := 0
+		for _, y := range rows {
+			total += int(AverageDeltaCROIConstMetric(in, y, c, roi, opts.metric()))
+		}
+		match := uint8(total / len(rows))
+		opts.trace(fmt.Sprintf("boards.segment.%d.color", i), c)
+		opts.trace(fmt.Sprintf("boards.segment.%d.match", i), match)
+		if match > opts.BoardColorThreshold {
+			continue
+		}
+
+		seg := BoardSegment{
+			Color: c,
+			Index: -1,
+		}
+
+		bottomColor := c
+		if palette != nil {
+			seg.Index = nearest(palette.DistancesMetric(c, opts.metric()), opts.PaletteMatchThreshold)
+			if seg.Index < 0 {
+				continue
+			}
+			seg.Name = palette[seg.Index].Name
+			bottomColor = palette[seg.Index].Color
+		}
+
+		det := &seg.BoardDetection
+		det.PixLeft, det.PixRight = x0, x1
+		det.Left = float32(x0) / float32(w)
+		det.Right = float32(x1) / float32(w)
+		det.LeftBlob, det.RightBlob = edgeBlobs[i], edgeBlobs[i + 1]
+		det.LeftStrength, det.RightStrength = edgeStrengths[i], edgeStrengths[i + 1]
+
+		leftVisible, rightVisible := det.LeftBlob >= 0, det.RightBlob >= 0
+		switch {
+		case leftVisible && rightVisible:
+			det.State = BoardBothEdges
+			det.Confidence = (float32(det.LeftStrength) + float32(det.RightStrength)) / (2 * 255)
+		case leftVisible:
+			det.State = BoardLeftEdge
+			det.Confidence = float32(det.LeftStrength) / 255
+		case rightVisible:
+			det.State = BoardRightEdge
+			det.Confidence = float32(det.RightStrength) / 255
+		default:
+			det.State = BoardFillsView
+			det.Confidence = 1.0 - float32(match) / 255
+		}
+
+		findBoardBottom(in, bottomColor, Tuple{ x0, x1 }, det, opts)
+
+		segs = append(segs, seg)
+	}
+
+	return segs
+}
@@ -0,0 +1,75 @@
+package cv
+
+import (
+	"testing"
+)
+
+func TestFindBoardsScene(t *testing.T) {
+	palette := DefaultPalette()
+	s := DefaultScene()
+	s.Boards = []SceneBoard{
+		{ Left: 0.05, Right: 0.3, Bottom: 0.7, Color: palette[0].Color },
+		// Touching the first, so there's only one edge between them
+		{ Left: 0.3, Right: 0.55, Bottom: 0.65, Color: palette[1].Color },
+		{ Left: 0.7, Right: 0.95, Bottom: 0.72, Color: palette[2].Color },
+	}
+	img, truth := s.Render()
+
+	segs := FindBoards(img, palette, nil)
+	if len(segs) != len(truth.Boards) {
+		t.Fatalf("found %d boards, want %d", len(segs), len(truth.Boards))
+	}
+	for i, seg := range segs {
+		bt := truth.Boards[i]
+		if seg.Index != i || seg.Name != palette[i].Name {
+			t.Errorf("board %d: classified as %d (%q)", i, seg.Index, seg.Name)
+		}
+		if seg.State != bt.State {
+			t.Errorf("board %d: state %v, want %v", i, seg.State, bt.State)
+		}
+		if absInt(seg.PixLeft - bt.PixLeft) > 4 || absInt(seg.PixRight - bt.PixRight) > 4 {
+			t.Errorf("board %d: from %d to %d, want %d to %d", i, seg.PixLeft, seg.PixRight, bt.PixLeft, bt.PixRight)
+		}
+		if absInt(seg.PixBottom - bt.PixBottom) > 6 {
+			t.Errorf("board %d: bottom %d, want %d", i, seg.PixBottom, bt.PixBottom)
+		}
+	}
+
+	// The adjacent boards share their split
+	if segs[0].PixRight != segs[1].PixLeft {
+		t.Errorf("adjacent boards split at %d and %d", segs[0].PixRight, segs[1].PixLeft)
+	}
+}
+
+// Without a palette the bits of wall come back too, and every split is
+// between two segments
+func TestFindBoardsNoPalette(t *testing.T) {
+	palette := DefaultPalette()
+	s := DefaultScene()
+	s.Boards = []SceneBoard{
+		{ Left: 0.05, Right: 0.3, Bottom: 0.7, Color: palette[0].Color },
+		{ Left: 0.7, Right: 0.95, Bottom: 0.72, Color: palette[2].Color },
+	}
+	img, truth := s.Render()
+	w, _ := ImageDims(img)
+
+	segs := FindBoards(img, nil, nil)
+	wantSplits := []int{ 0, truth.Boards[0].PixLeft, truth.Boards[0].PixRight, truth.Boards[1].PixLeft, truth.Boards[1].PixRight, w }
+	if len(segs) != len(wantSplits) - 1 {
+		t.Fatalf("found %d segments, want %d", len(segs), len(wantSplits) - 1)
+	}
+	for i, seg := range segs {
+		if seg.Index != -1 || seg.Name != "" {
+			t.Errorf("segment %d classified without a palette", i)
+		}
+		if absInt(seg.PixLeft - wantSplits[i]) > 4 || absInt(seg.PixRight - wantSplits[i + 1]) > 4 {
+			t.Errorf("segment %d from %d to %d, want %d to %d", i, seg.PixLeft, seg.PixRight, wantSplits[i], wantSplits[i + 1])
+		}
+		if i > 0 && segs[i - 1].PixRight != seg.PixLeft {
+			t.Errorf("gap between segments %d and %d", i - 1, i)
+		}
+	}
+	if segs[0].State != BoardRightEdge || segs[len(segs) - 1].State != BoardLeftEdge {
+		t.Errorf("outer segments are %v and %v", segs[0].State, segs[len(segs) - 1].State)
+	}
+}
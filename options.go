@@ -0,0 +1,180 @@
+package cv
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// Tunables for all of the detectors. These can be saved and loaded as JSON,
+// so that each venue can have its own profile.
+type Options struct {
+	// Used on the edge images (the output of DeltaCByCol and friends)
+	EdgeThreshold ThresholdOptions `json:"edge_threshold"`
+	// Used on the line projections (the output of FindVerticalLines and
+	// FindHorizontalLines)
+	LineThreshold ThresholdOptions `json:"line_threshold"`
+
+	// The line projections sum over stripes 1/LineStripes of the image
+	LineStripes int `json:"line_stripes"`
+
+	// Board edges are rounded up to a multiple of this many pixels, to
+	// keep them on chroma sample boundaries
+	EdgeAlign int `json:"edge_align"`
+
+	// When looking for both edges of a single board, rows with fewer or
+	// more edges than this are assumed to be noise. The outermost two edges
+	// are taken as the board. When only one edge is in view, the same slack
+	// is allowed either side of one edge per row.
+	MinRowEdges int `json:"min_row_edges"`
+	MaxRowEdges int `json:"max_row_edges"`
+	// The same for FindBoards, which can have many boards in a row
+	MaxRowEdgesMulti int `json:"max_row_edges_multi"`
+
+	// Maximum average DeltaC between a region and the board colour for the
+	// region to be considered part of the board
+	BoardColorThreshold uint8 `json:"board_color_threshold"`
+	// Maximum distance to the nearest palette colour for it to be
+	// considered a match
+	PaletteMatchThreshold uint8 `json:"palette_match_threshold"`
+
+	// Maximum distance (in edge image pixels) from the horizon line for a
+	// point to count as on it
+	HorizonInlierDist float64 `json:"horizon_inlier_dist"`
+	// Horizon lines steeper than this (in edge image pixels) aren't
+	// considered
+	HorizonMaxSlope float64 `json:"horizon_max_slope"`
+	// Number of RANSAC iterations when fitting the horizon line
+	HorizonIterations int `json:"horizon_iterations"`
+
+	// How colour differences are measured. Saved by name, see
+	// RegisterColorMetric
+	Metric ColorMetric `json:"-"`
+
+	// If set, receives the intermediate results of the detectors
+	Tracer Tracer `json:"-"`
+}
+
+// The behaviour the detectors have always had
+func DefaultOptions() *Options {
+	return &Options{
+		EdgeThreshold: ThresholdOptions{
+			Mode: FixedThreshold,
+			Level: 128,
+			Low: 64,
+			Window: 15,
+			Offset: 16,
+		},
+		LineThreshold: ThresholdOptions{
+			Mode: FixedThreshold,
+			Level: 128,
+			Low: 64,
+			Window: 15,
+			Offset: 16,
+		},
+		LineStripes: 32,
+		EdgeAlign: 2,
+		MinRowEdges: 2,
+		MaxRowEdges: 2,
+		MaxRowEdgesMulti: 12,
+		BoardColorThreshold: 48,
+		PaletteMatchThreshold: 64,
+		HorizonInlierDist: 1.5,
+		HorizonMaxSlope: 1.0,
+		HorizonIterations: 200,
+		Metric: DefaultMetric,
+	}
+}
+
+func (o *Options) orDefault() *Options {
+	if o == nil {
+		return DefaultOptions()
+	}
+	return o
+}
+
+func (o *Options) metric() ColorMetric {
+	return metricOrDefault(o.Metric)
+}
+
+// Options are saved with the metric's name in place of the metric
+type plainOptions Options
+
+type optionsJSON struct {
+	*plainOptions
+	Metric string `json:"metric"`
+}
+
+func (o *Options) MarshalJSON() ([]byte, error) {
+	return json.Marshal(optionsJSON{ (*plainOptions)(o), o.metric().Name() })
+}
+
+func (o *Options) UnmarshalJSON(data []byte) error {
+	aux := optionsJSON{ (*plainOptions)(o), o.metric().Name() }
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	m, err := ColorMetricByName(aux.Metric)
+	if err != nil {
+		return err
+	}
+	o.Metric = m
+
+	return nil
+}
+
+func (o *Options) Validate() error {
+	if err := o.EdgeThreshold.Validate(); err != nil {
+		return fmt.Errorf("edge_threshold: %v", err)
+	}
+	if err := o.LineThreshold.Validate(); err != nil {
+		return fmt.Errorf("line_threshold: %v", err)
+	}
+	if o.LineStripes < 1 {
+		return fmt.Errorf("line_stripes must be at least 1")
+	}
+	// RoundUp only works for powers of two
+	if o.EdgeAlign < 1 || o.EdgeAlign & (o.EdgeAlign - 1) != 0 {
+		return fmt.Errorf("edge_align must be a power of two")
+	}
+	if o.MinRowEdges < 1 || o.MaxRowEdges < o.MinRowEdges || o.MaxRowEdges < 2 {
+		return fmt.Errorf("need 1 <= min_row_edges <= max_row_edges, and max_row_edges >= 2")
+	}
+	if o.MaxRowEdgesMulti < 1 {
+		return fmt.Errorf("max_row_edges_multi must be at least 1")
+	}
+	if o.HorizonIterations < 1 {
+		return fmt.Errorf("horizon_iterations must be at least 1")
+	}
+	return nil
+}
+
+// Loads options from a JSON file. Anything not in the file keeps its default
+// value.
+func LoadOptions(path string) (*Options, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	o := DefaultOptions()
+	if err := json.Unmarshal(data, o); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	if err := o.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return o, nil
+}
+
+func SaveOptions(path string, o *Options) error {
+	data, err := json.MarshalIndent(o, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,442 @@
+package cv
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// Draws annotations onto a frame, for showing what the detectors found.
+//
+// Coordinates are in pixels relative to the frame's top-left corner, the same
+// as the detectors' results.
+type Overlay struct {
+	img image.Image
+	rect image.Rectangle
+
+	// Size of each dot of the label font, in pixels
+	Scale int
+}
+
+// Anything which knows how to draw itself onto an Overlay
+type Drawable interface {
+	DrawOverlay(o *Overlay)
+}
+
+var (
+	OverlayHighlight = color.RGBA{ 0x80, 0, 0, 0x80 }
+	OverlayEdge = color.RGBA{ 0xff, 0xff, 0, 0xff }
+	OverlayHorizon = color.RGBA{ 0, 0xff, 0xff, 0xff }
+	OverlayText = color.RGBA{ 0xff, 0xff, 0xff, 0xff }
+	overlayLabelBg = color.RGBA{ 0, 0, 0, 0xa0 }
+)
+
+// img must be a draw.Image (e.g. *image.RGBA) or an *image.YCbCr
+func NewOverlay(img image.Image) (*Overlay, error) {
+	switch img.(type) {
+	case draw.Image, *image.YCbCr:
+	default:
+		return nil, fmt.Errorf("can't draw onto %T", img)
+	}
+
+	w, _ := ImageDims(img)
+	return &Overlay{
+		img: img,
+		rect: img.Bounds(),
+		Scale: max(1, w / 320),
+	}, nil
+}
+
+func (o *Overlay) Image() image.Image {
+	return o.img
+}
+
+func (o *Overlay) Draw(ds ...Drawable) {
+	for _, d := range ds {
+		d.DrawOverlay(o)
+	}
+}
+
+func blend8(dst, src, a uint8) uint8 {
+	return uint8((int(dst) * (255 - int(a)) + int(src) * int(a) + 127) / 255)
+}
+
+// Straight (non-premultiplied) 8-bit values of c
+func overlayRGBA(c color.Color) (r, g, b, a uint8) {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	return n.R, n.G, n.B, n.A
+}
+
+// Plots a single pixel at frame coordinates (x, y). Only safe for opaque
+// colours on YCbCr frames, as the chroma sample is shared with neighbouring
+// pixels and would be blended more than once.
+func (o *Overlay) set(x, y int, c color.Color) {
+	x, y = x + o.rect.Min.X, y + o.rect.Min.Y
+	if !(image.Point{ x, y }).In(o.rect) {
+		return
+	}
+
+	switch img := o.img.(type) {
+	case *image.YCbCr:
+		r, g, b, a := overlayRGBA(c)
+		if a == 0 {
+			return
+		}
+		yy, cb, cr := color.RGBToYCbCr(r, g, b)
+
+		yi := img.YOffset(x, y)
+		ci := img.COffset(x, y)
+		img.Y[yi] = blend8(img.Y[yi], yy, a)
+		img.Cb[ci] = blend8(img.Cb[ci], cb, a)
+		img.Cr[ci] = blend8(img.Cr[ci], cr, a)
+	case draw.Image:
+		_, _, _, a := c.RGBA()
+		if a == 0xffff {
+			img.Set(x, y, c)
+			return
+		}
+		draw.Draw(img, image.Rect(x, y, x + 1, y + 1), image.NewUniform(c), image.ZP, draw.Over)
+	}
+}
+
+// Fills r with c, which may be translucent
+func (o *Overlay) FillRect(r image.Rectangle, c color.Color) {
+	r = r.Add(o.rect.Min).Intersect(o.rect)
+	if r.Empty() {
+		return
+	}
+
+	switch img := o.img.(type) {
+	case *image.YCbCr:
+		rr, g, b, a := overlayRGBA(c)
+		if a == 0 {
+			return
+		}
+		yy, cb, cr := color.RGBToYCbCr(rr, g, b)
+		hsub, vsub := SubsampleFactors(img.SubsampleRatio)
+
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			// Each chroma sample must only be blended once
+			doChroma := y == r.Min.Y || y % vsub == 0
+			for x := r.Min.X; x < r.Max.X; x++ {
+				yi := img.YOffset(x, y)
+				img.Y[yi] = blend8(img.Y[yi], yy, a)
+
+				if doChroma && (x == r.Min.X || x % hsub == 0) {
+					ci := img.COffset(x, y)
+					img.Cb[ci] = blend8(img.Cb[ci], cb, a)
+					img.Cr[ci] = blend8(img.Cr[ci], cr, a)
+				}
+			}
+		}
+	case draw.Image:
+		draw.Draw(img, r, image.NewUniform(c), image.ZP, draw.Over)
+	}
+}
+
+// Liang-Barsky: the part of the line from (x0, y0) to (x1, y1) which lies
+// inside r, with r.Max exclusive as usual. ok is false if none of it does.
+func clipLine(x0, y0, x1, y1 float64, r image.Rectangle) (cx0, cy0, cx1, cy1 float64, ok bool) {
+	dx, dy := x1 - x0, y1 - y0
+	t0, t1 := 0.0, 1.0
+
+	edges := [4][2]float64{
+		{ -dx, x0 - float64(r.Min.X) },
+		{ dx, float64(r.Max.X - 1) - x0 },
+		{ -dy, y0 - float64(r.Min.Y) },
+		{ dy, float64(r.Max.Y - 1) - y0 },
+	}
+	for _, e := range edges {
+		p, q := e[0], e[1]
+		if p == 0 {
+			// Parallel to this edge
+			if q < 0 {
+				return 0, 0, 0, 0, false
+			}
+			continue
+		}
+
+		t := q / p
+		if p < 0 {
+			if t > t1 {
+				return 0, 0, 0, 0, false
+			}
+			t0 = math.Max(t0, t)
+		} else {
+			if t < t0 {
+				return 0, 0, 0, 0, false
+			}
+			t1 = math.Min(t1, t)
+		}
+	}
+
+	return x0 + t0 * dx, y0 + t0 * dy, x0 + t1 * dx, y0 + t1 * dy, true
+}
+
+// Draws a line from (x0, y0) to (x1, y1), inclusive. Only the part inside the
+// frame is drawn, so the ends can be anywhere.
+func (o *Overlay) Line(x0, y0, x1, y1 int, c color.Color) {
+	frame := image.Rect(0, 0, o.rect.Dx(), o.rect.Dy())
+	if !image.Pt(x0, y0).In(frame) || !image.Pt(x1, y1).In(frame) {
+		fx0, fy0, fx1, fy1, ok := clipLine(float64(x0), float64(y0), float64(x1), float64(y1), frame)
+		if !ok {
+			return
+		}
+		x0, y0 = int(math.Round(fx0)), int(math.Round(fy0))
+		x1, y1 = int(math.Round(fx1)), int(math.Round(fy1))
+	}
+
+	dx, dy := x1 - x0, y1 - y0
+	if dx < 0 {
+		dx = -dx
+	}
+	if dy < 0 {
+		dy = -dy
+	}
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+
+	// Bresenham
+	err := dx - dy
+	for {
+		o.set(x0, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * err
+		if e2 > -dy {
+			err -= dy
+			x0 += sx
+		}
+		if e2 < dx {
+			err += dx
+			y0 += sy
+		}
+	}
+}
+
+// Draws the outline of r, just inside it
+func (o *Overlay) Rect(r image.Rectangle, c color.Color) {
+	if r.Empty() {
+		return
+	}
+	x0, y0, x1, y1 := r.Min.X, r.Min.Y, r.Max.X - 1, r.Max.Y - 1
+	o.Line(x0, y0, x1, y0, c)
+	o.Line(x0, y1, x1, y1, c)
+	o.Line(x0, y0, x0, y1, c)
+	o.Line(x1, y0, x1, y1, c)
+}
+
+// Draws a cross centred on (x, y)
+func (o *Overlay) Marker(x, y int, c color.Color) {
+	r := 2 * o.Scale
+	o.Line(x - r, y, x + r, y, c)
+	o.Line(x, y - r, x, y + r, c)
+}
+
+// Draws a square of solid colour c with its top-left corner at (x, y)
+func (o *Overlay) Swatch(x, y int, c color.Color) {
+	size := 8 * o.Scale
+	r := image.Rect(x, y, x + size, y + size)
+
+	// Drop any alpha, it's the colour which is interesting
+	rr, g, b, _ := overlayRGBA(c)
+	o.FillRect(r, color.RGBA{ rr, g, b, 0xff })
+	o.Rect(r, OverlayText)
+}
+
+// Full-width line at normalised row y, as returned by FindHorizon
+func (o *Overlay) HorizonRow(y float32, c color.Color) {
+	w, h := ImageDims(o.img)
+	fy := float64(y) * float64(h)
+	// Also catches NaN
+	if !(fy >= 0 && fy < float64(h)) {
+		return
+	}
+	row := int(fy)
+	o.Line(0, row, w - 1, row, c)
+}
+
+// Marks the centre of each blob along the top and bottom of the frame, for
+// blobs found in a vertical line projection (i.e. x coordinates)
+func (o *Overlay) ColumnBlobs(blobs []Tuple, c color.Color) {
+	_, h := ImageDims(o.img)
+	for _, b := range blobs {
+		x := (b.First + b.Second) / 2
+		o.Marker(x, 2 * o.Scale, c)
+		o.Marker(x, h - 1 - 2 * o.Scale, c)
+	}
+}
+
+// As ColumnBlobs, for blobs found in a horizontal line projection (i.e. y
+// coordinates), marked along the left and right of the frame
+func (o *Overlay) RowBlobs(blobs []Tuple, c color.Color) {
+	w, _ := ImageDims(o.img)
+	for _, b := range blobs {
+		y := (b.First + b.Second) / 2
+		o.Marker(2 * o.Scale, y, c)
+		o.Marker(w - 1 - 2 * o.Scale, y, c)
+	}
+}
+
+// 3x5 glyphs, one byte per row, MSB on the left
+var overlayFont = map[rune][5]uint8{
+	'0': { 7, 5, 5, 5, 7 }, '1': { 2, 6, 2, 2, 7 }, '2': { 7, 1, 7, 4, 7 },
+	'3': { 7, 1, 3, 1, 7 }, '4': { 5, 5, 7, 1, 1 }, '5': { 7, 4, 7, 1, 7 },
+	'6': { 7, 4, 7, 5, 7 }, '7': { 7, 1, 1, 1, 1 }, '8': { 7, 5, 7, 5, 7 },
+	'9': { 7, 5, 7, 1, 7 },
+	'A': { 2, 5, 7, 5, 5 }, 'B': { 6, 5, 6, 5, 6 }, 'C': { 3, 4, 4, 4, 3 },
+	'D': { 6, 5, 5, 5, 6 }, 'E': { 7, 4, 6, 4, 7 }, 'F': { 7, 4, 6, 4, 4 },
+	'G': { 3, 4, 5, 5, 3 }, 'H': { 5, 5, 7, 5, 5 }, 'I': { 7, 2, 2, 2, 7 },
+	'J': { 1, 1, 1, 5, 2 }, 'K': { 5, 5, 6, 5, 5 }, 'L': { 4, 4, 4, 4, 7 },
+	'M': { 5, 7, 7, 5, 5 }, 'N': { 6, 5, 5, 5, 5 }, 'O': { 2, 5, 5, 5, 2 },
+	'P': { 6, 5, 6, 4, 4 }, 'Q': { 2, 5, 5, 6, 3 }, 'R': { 6, 5, 6, 5, 5 },
+	'S': { 3, 4, 2, 1, 6 }, 'T': { 7, 2, 2, 2, 2 }, 'U': { 5, 5, 5, 5, 7 },
+	'V': { 5, 5, 5, 5, 2 }, 'W': { 5, 5, 7, 7, 5 }, 'X': { 5, 5, 2, 5, 5 },
+	'Y': { 5, 5, 2, 2, 2 }, 'Z': { 7, 1, 2, 4, 7 },
+	'.': { 0, 0, 0, 0, 2 }, ':': { 0, 2, 0, 2, 0 }, '-': { 0, 0, 7, 0, 0 },
+	'%': { 5, 1, 2, 4, 5 }, '/': { 1, 1, 2, 4, 4 }, '(': { 1, 2, 2, 2, 1 },
+	')': { 4, 2, 2, 2, 4 }, '=': { 0, 7, 0, 7, 0 }, '_': { 0, 0, 0, 0, 7 },
+	'?': { 7, 1, 2, 0, 2 }, ' ': { 0, 0, 0, 0, 0 },
+}
+
+// Draws text with its top-left corner at (x, y), on a dark background so it
+// can be read over anything. Only upper case letters, digits and a little
+// punctuation are available; lower case is drawn as upper case.
+func (o *Overlay) Label(x, y int, text string, c color.Color) {
+	s := o.Scale
+	runes := []rune(text)
+	if len(runes) == 0 {
+		return
+	}
+
+	o.FillRect(image.Rect(x, y, x + (len(runes) * 4 + 1) * s, y + 7 * s), overlayLabelBg)
+
+	for i, r := range runes {
+		if r >= 'a' && r <= 'z' {
+			r += 'A' - 'a'
+		}
+		glyph, ok := overlayFont[r]
+		if !ok {
+			glyph = overlayFont['?']
+		}
+
+		gx := x + (i * 4 + 1) * s
+		for row, bits := range glyph {
+			for col := 0; col < 3; col++ {
+				if bits & (4 >> uint(col)) == 0 {
+					continue
+				}
+				px, py := gx + col * s, y + (row + 1) * s
+				o.FillRect(image.Rect(px, py, px + s, py + s), c)
+			}
+		}
+	}
+}
+
+// The region covered by the board, with edges which aren't visible at the
+// frame edges
+func (d BoardDetection) rect(w, h int) image.Rectangle {
+	r := image.Rect(0, 0, w, h)
+	if d.PixLeft >= 0 {
+		r.Min.X = d.PixLeft
+	}
+	if d.PixRight >= 0 {
+		r.Max.X = d.PixRight
+	}
+	if d.PixBottom >= 0 {
+		r.Max.Y = d.PixBottom
+	}
+	return r
+}
+
+func (d BoardDetection) drawEdges(o *Overlay, c color.Color) image.Rectangle {
+	w, h := ImageDims(o.img)
+	r := d.rect(w, h)
+
+	if d.State == BoardBothEdges || d.State == BoardLeftEdge {
+		o.Line(r.Min.X, 0, r.Min.X, h - 1, c)
+	}
+	if d.State == BoardBothEdges || d.State == BoardRightEdge {
+		o.Line(r.Max.X - 1, 0, r.Max.X - 1, h - 1, c)
+	}
+	if d.PixBottom >= 0 {
+		o.Line(r.Min.X, r.Max.Y - 1, r.Max.X - 1, r.Max.Y - 1, c)
+	}
+
+	return r
+}
+
+// Highlights the board, marks its visible edges and labels it with its state
+// and confidence
+func (d BoardDetection) DrawOverlay(o *Overlay) {
+	if d.State == BoardNotFound {
+		o.Label(o.Scale, o.Scale, d.State.String(), OverlayText)
+		return
+	}
+
+	w, h := ImageDims(o.img)
+	o.FillRect(d.rect(w, h), OverlayHighlight)
+	r := d.drawEdges(o, OverlayEdge)
+
+	label := fmt.Sprintf("%s %d%%", d.State, int(d.Confidence * 100))
+	o.Label(r.Min.X + o.Scale, o.Scale, label, OverlayText)
+}
+
+// Outlines the segment in its own colour, with a swatch and its palette name
+func (s BoardSegment) DrawOverlay(o *Overlay) {
+	c := s.Color
+	if c == nil {
+		c = OverlayEdge
+	}
+	rr, g, b, _ := overlayRGBA(c)
+	solid := color.RGBA{ rr, g, b, 0xff }
+
+	w, h := ImageDims(o.img)
+	r := s.rect(w, h)
+	o.Rect(r, solid)
+	s.drawEdges(o, OverlayEdge)
+
+	x, y := r.Min.X + o.Scale, o.Scale
+	o.Swatch(x, y, c)
+	if s.Name != "" {
+		o.Label(x, y + 9 * o.Scale, s.Name, OverlayText)
+	}
+}
+
+// Draws the board, labelled with the matched colour name
+func (c ColorClassification) DrawOverlay(o *Overlay) {
+	c.Board.DrawOverlay(o)
+
+	name := c.Name
+	if c.Index < 0 {
+		name = "no match"
+	}
+	w, h := ImageDims(o.img)
+	r := c.Board.rect(w, h)
+	o.Label(r.Min.X + o.Scale, 9 * o.Scale, name, OverlayText)
+}
+
+func (l HorizonLine) DrawOverlay(o *Overlay) {
+	w, h := ImageDims(o.img)
+	y0 := float64(l.At(0)) * float64(h)
+	y1 := float64(l.At(1)) * float64(h)
+	if math.IsNaN(y0 - y1) || math.IsInf(y0 - y1, 0) {
+		return
+	}
+
+	// Clip before converting to pixels, as a steep line can be a long way
+	// out of int range by the edge of the frame
+	x0, y0, x1, y1, ok := clipLine(0, y0, float64(w - 1), y1, image.Rect(0, 0, w, h))
+	if !ok {
+		return
+	}
+	o.Line(int(math.Floor(x0)), int(math.Floor(y0)), int(math.Floor(x1)), int(math.Floor(y1)), OverlayHorizon)
+}
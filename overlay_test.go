@@ -0,0 +1,227 @@
+package cv
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func blankRGBA(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xff
+	}
+	return img
+}
+
+// Which pixels aren't black any more
+func drawnPixels(img *image.RGBA) map[image.Point]bool {
+	got := map[image.Point]bool{}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if c := img.RGBAAt(x, y); c.R != 0 || c.G != 0 || c.B != 0 {
+				got[image.Pt(x - b.Min.X, y - b.Min.Y)] = true
+			}
+		}
+	}
+	return got
+}
+
+func checkDrawn(t *testing.T, name string, img *image.RGBA, want []image.Point) {
+	t.Helper()
+	got := drawnPixels(img)
+	for _, p := range want {
+		if !got[p] {
+			t.Errorf("%s: %v not drawn", name, p)
+		}
+		delete(got, p)
+	}
+	for p := range got {
+		t.Errorf("%s: %v drawn", name, p)
+	}
+}
+
+func TestOverlayLine(t *testing.T) {
+	w, h := 20, 10
+	row := func(y, x0, x1 int) []image.Point {
+		var pts []image.Point
+		for x := x0; x <= x1; x++ {
+			pts = append(pts, image.Pt(x, y))
+		}
+		return pts
+	}
+	diag := func(n int) []image.Point {
+		var pts []image.Point
+		for i := 0; i < n; i++ {
+			pts = append(pts, image.Pt(i, i))
+		}
+		return pts
+	}
+
+	cases := []struct {
+		name string
+		x0, y0, x1, y1 int
+		want []image.Point
+	}{
+		{ "row", 2, 3, 12, 3, row(3, 2, 12) },
+		{ "backwards", 12, 3, 2, 3, row(3, 2, 12) },
+		{ "column", 4, 1, 4, 3, []image.Point{ { 4, 1 }, { 4, 2 }, { 4, 3 } } },
+		{ "point", 5, 5, 5, 5, []image.Point{ { 5, 5 } } },
+		{ "diagonal", 0, 0, 9, 9, diag(10) },
+		{ "shallow", 0, 0, 4, 2, []image.Point{ { 0, 0 }, { 1, 0 }, { 2, 1 }, { 3, 1 }, { 4, 2 } } },
+		// Hanging off the frame, by a little and by a lot
+		{ "clipped row", -5, 7, 100, 7, row(7, 0, w - 1) },
+		{ "huge row", -1 << 30, 7, 1 << 30, 7, row(7, 0, w - 1) },
+		{ "clipped diagonal", -100, -100, 100, 100, diag(h) },
+		{ "huge diagonal", -1 << 30, -1 << 30, 1 << 30, 1 << 30, diag(h) },
+		{ "outside", -10, -3, 40, -1, nil },
+		{ "miss the corner", 25, 5, 15, -5, nil },
+	}
+
+	for _, c := range cases {
+		img := blankRGBA(w, h)
+		o, err := NewOverlay(img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		o.Line(c.x0, c.y0, c.x1, c.y1, OverlayText)
+		checkDrawn(t, c.name, img, c.want)
+	}
+}
+
+// Coordinates are relative to the frame, which needn't be at the origin
+func TestOverlayLineSubImage(t *testing.T) {
+	full := blankRGBA(30, 20)
+	img := full.SubImage(image.Rect(5, 4, 25, 14)).(*image.RGBA)
+	o, _ := NewOverlay(img)
+	o.Line(-1000, 2, 1000, 2, OverlayText)
+
+	var want []image.Point
+	for x := 0; x < 20; x++ {
+		want = append(want, image.Pt(x, 2))
+	}
+	checkDrawn(t, "sub-image", img, want)
+
+	if got := len(drawnPixels(full)); got != 20 {
+		t.Errorf("%d pixels drawn in the whole image, want 20", got)
+	}
+}
+
+func TestHorizonLineOverlay(t *testing.T) {
+	w, h := 40, 20
+
+	var row10 []image.Point
+	for x := 0; x < w; x++ {
+		row10 = append(row10, image.Pt(x, 10))
+	}
+
+	for _, c := range []struct {
+		name string
+		line HorizonLine
+		want []image.Point
+	}{
+		{ "level", HorizonLine{ Slope: 0, Intercept: 0.5 }, row10 },
+		{ "above", HorizonLine{ Slope: 0, Intercept: -0.5 }, nil },
+		{ "below", HorizonLine{ Slope: 0.1, Intercept: 3 }, nil },
+		{ "far away", HorizonLine{ Slope: 0, Intercept: 1e30 }, nil },
+		{ "NaN", HorizonLine{ Slope: float32(math.NaN()), Intercept: 0.5 }, nil },
+		{ "vertical", HorizonLine{ Slope: float32(math.Inf(1)), Intercept: 0.5 }, nil },
+	} {
+		img := blankRGBA(w, h)
+		o, _ := NewOverlay(img)
+		c.line.DrawOverlay(o)
+		checkDrawn(t, c.name, img, c.want)
+	}
+
+	// Steep enough that the ends are far outside int32, crossing the middle
+	// of the frame. Each row should get a pixel near the middle column.
+	img := blankRGBA(w, h)
+	o, _ := NewOverlay(img)
+	HorizonLine{ Slope: 1e12, Intercept: 0.5 - 0.5e12 }.DrawOverlay(o)
+
+	got := drawnPixels(img)
+	rows := map[int]bool{}
+	for p := range got {
+		rows[p.Y] = true
+		if p.X < w / 2 - 2 || p.X > w / 2 + 1 {
+			t.Errorf("steep line drawn at %v", p)
+		}
+	}
+	if len(rows) != h {
+		t.Errorf("steep line covers %d rows, want %d", len(rows), h)
+	}
+}
+
+func TestHorizonRowOverlay(t *testing.T) {
+	for _, y := range []float32{ -0.1, 1, 1e30, float32(math.Inf(-1)), float32(math.NaN()) } {
+		img := blankRGBA(20, 10)
+		o, _ := NewOverlay(img)
+		o.HorizonRow(y, OverlayHorizon)
+		checkDrawn(t, "off the frame", img, nil)
+	}
+
+	img := blankRGBA(20, 10)
+	o, _ := NewOverlay(img)
+	o.HorizonRow(0.75, OverlayHorizon)
+	var want []image.Point
+	for x := 0; x < 20; x++ {
+		want = append(want, image.Pt(x, 7))
+	}
+	checkDrawn(t, "row", img, want)
+}
+
+func TestBoardDetectionOverlay(t *testing.T) {
+	w, h := 64, 48
+	img := blankRGBA(w, h)
+	o, _ := NewOverlay(img)
+
+	d := BoardDetection{ State: BoardBothEdges, PixLeft: 20, PixRight: 40, PixBottom: 30, Confidence: 1 }
+	d.DrawOverlay(o)
+
+	edge := color.RGBAModel.Convert(OverlayEdge).(color.RGBA)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := img.RGBAAt(x, y)
+			switch {
+			case y < 8 * o.Scale:
+				// The label, which is drawn last
+			case x == 20 || x == 39 || (y == 29 && x >= 20 && x < 40):
+				if c != edge {
+					t.Errorf("edge at (%d, %d) is %v", x, y, c)
+				}
+			case x > 20 && x < 39 && y < 29:
+				if c.R == 0 || c.G != 0 || c.B != 0 {
+					t.Errorf("board at (%d, %d) is %v, should be highlighted", x, y, c)
+				}
+			default:
+				if c != (color.RGBA{ 0, 0, 0, 0xff }) {
+					t.Errorf("(%d, %d) is %v, should be untouched", x, y, c)
+				}
+			}
+		}
+	}
+}
+
+// On YCbCr frames, only the pixels on the line change luma
+func TestOverlayLineYCbCr(t *testing.T) {
+	img := image.NewYCbCr(image.Rect(0, 0, 16, 8), image.YCbCrSubsampleRatio420)
+	o, err := NewOverlay(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Line(-50, 3, 50, 3, color.White)
+
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			want := uint8(0)
+			if y == 3 {
+				want = 255
+			}
+			if got := img.Y[img.YOffset(x, y)]; got != want {
+				t.Errorf("(%d, %d) luma %d, want %d", x, y, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package cv
+
+import (
+	"image"
+	"image/color"
+	"strings"
+)
+
+type NamedColor struct {
+	Name string
+	Color color.Color
+}
+
+// Palette colours should be the same type as the images they will be compared
+// against (e.g. color.YCbCr for *image.YCbCr frames), otherwise DeltaC falls
+// back to its slower, differently-scaled RGB metric.
+type Palette []NamedColor
+
+func ycbcrFromRGB(r, g, b uint8) color.YCbCr {
+	y, cb, cr := color.RGBToYCbCr(r, g, b)
+	return color.YCbCr{ Y: y, Cb: cb, Cr: cr }
+}
+
+// The "Over the Rainbow" board colours
+func DefaultPalette() Palette {
+	return Palette{
+		{ Name: "red", Color: ycbcrFromRGB(0xc0, 0x20, 0x20) },
+		{ Name: "green", Color: ycbcrFromRGB(0x20, 0xa0, 0x40) },
+		{ Name: "blue", Color: ycbcrFromRGB(0x20, 0x40, 0xc0) },
+		{ Name: "yellow", Color: ycbcrFromRGB(0xe0, 0xd0, 0x20) },
+	}
+}
+
+type ColorClassification struct {
+	// Index into the palette of the closest colour, -1 if nothing was close
+	// enough. Name is empty if Index is -1
+	Index int
+	Name string
+
+	// Distance to each colour in the palette, same order as the palette
+	Distances []uint8
+
+	// Result of DetectBoard using the matched colour, or with no colour if
+	// there was no match
+	Board BoardDetection
+}
+
+// DeltaC between c and each colour in p
+func (p Palette) Distances(c color.Color) []uint8 {
+	return p.DistancesMetric(c, DefaultMetric)
+}
+
+// As Distances, measuring differences with m
+func (p Palette) DistancesMetric(c color.Color, m ColorMetric) []uint8 {
+	m = metricOrDefault(m)
+	dists := make([]uint8, len(p))
+	for i, pc := range p {
+		dists[i] = m.Delta(c, pc.Color)
+	}
+
+	return dists
+}
+
+// Returns the index of the colour called name (ignoring case), or -1
+func (p Palette) Find(name string) int {
+	for i, pc := range p {
+		if strings.EqualFold(pc.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Returns the index of the closest colour in p (or -1 if none are within the
+// default PaletteMatchThreshold), and the distance to each one
+func (p Palette) Nearest(c color.Color) (int, []uint8) {
+	dists := p.Distances(c)
+	return nearest(dists, DefaultOptions().PaletteMatchThreshold), dists
+}
+
+func nearest(dists []uint8, maxDist uint8) int {
+	idx := -1
+	best := 255
+	for i, d := range dists {
+		if int(d) < best {
+			best = int(d)
+			idx = i
+		}
+	}
+
+	if best > int(maxDist) {
+		return -1
+	}
+	return idx
+}
+
+// We don't know where the bottom of a board is until we know its colour, so
+// sample a few rows in the top half of the frame where it is most likely to be
+func boardSampleRows(h int) []int {
+	return []int{ h / 4, (3 * h) / 8, h / 2 }
+}
+
+// Works out which of the colours in palette is the board in view, and where
+// it is. opts may be nil, to use DefaultOptions
+func ClassifyBoard(in image.Image, palette Palette, opts *Options) ColorClassification {
+	opts = opts.orDefault()
+	cls := ColorClassification{
+		Index: -1,
+		Distances: make([]uint8, len(palette)),
+	}
+
+	// First find out where the board is without caring about its colour. That
+	// only works with both edges in view, otherwise each colour has to be tried
+	// in turn and the board is wherever that colour puts it
+	cls.Board = DetectBoard(in, nil, image.Rectangle{}, opts)
+
+	_, h := ImageDims(in)
+	rows := boardSampleRows(h)
+	for i, pc := range palette {
+		det := cls.Board
+		if det.State != BoardBothEdges {
+			det = DetectBoard(in, pc.Color, image.Rectangle{}, opts)
+		}
+		if det.State == BoardNotFound || det.PixRight <= det.PixLeft {
+			cls.Distances[i] = 255
+			continue
+		}
+
+		roi := image.Rect(det.PixLeft, 0, det.PixRight, h)
+		total := 0
+		for _, y := range rows {
+			total += int(AverageDeltaCROIConstMetric(in, y, pc.Color, roi, opts.metric()))
+		}
+		cls.Distances[i] = uint8(total / len(rows))
+	}
+	opts.trace("classify.distances", cls.Distances)
+
+	cls.Index = nearest(cls.Distances, opts.PaletteMatchThreshold)
+	if cls.Index < 0 {
+		return cls
+	}
+
+	cls.Name = palette[cls.Index].Name
+	cls.Board = DetectBoard(in, palette[cls.Index].Color, image.Rectangle{}, opts)
+
+	return cls
+}
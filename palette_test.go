@@ -0,0 +1,60 @@
+package cv
+
+import (
+	"testing"
+)
+
+// Every palette colour, in the middle, off each side and filling the view
+func TestClassifyBoardScenes(t *testing.T) {
+	positions := []struct {
+		name string
+		left, right float64
+	}{
+		{ "middle", 0.35, 0.65 },
+		{ "off left", -0.2, 0.4 },
+		{ "off right", 0.6, 1.2 },
+		{ "fills view", -0.1, 1.1 },
+	}
+
+	palette := DefaultPalette()
+	for i, pc := range palette {
+		for _, pos := range positions {
+			s := DefaultScene()
+			s.Boards[0].Color = pc.Color
+			s.Boards[0].Left, s.Boards[0].Right = pos.left, pos.right
+			s.Noise = 2
+			s.Seed = int64(i)
+			img, truth := s.Render()
+			bt := truth.Boards[0]
+
+			cls := ClassifyBoard(img, palette, nil)
+			if cls.Index != i || cls.Name != pc.Name {
+				t.Errorf("%s %s: classified as %d (%q), distances %v", pc.Name, pos.name, cls.Index, cls.Name, cls.Distances)
+				continue
+			}
+			if cls.Board.State != bt.State {
+				t.Errorf("%s %s: state %v, want %v", pc.Name, pos.name, cls.Board.State, bt.State)
+			}
+			if absInt(cls.Board.PixLeft - bt.PixLeft) > 4 || absInt(cls.Board.PixRight - bt.PixRight) > 4 {
+				t.Errorf("%s %s: board from %d to %d, want %d to %d", pc.Name, pos.name,
+					cls.Board.PixLeft, cls.Board.PixRight, bt.PixLeft, bt.PixRight)
+			}
+		}
+	}
+}
+
+func TestClassifyBoardNoBoard(t *testing.T) {
+	s := DefaultScene()
+	s.Boards = nil
+	img, _ := s.Render()
+
+	cls := ClassifyBoard(img, DefaultPalette(), nil)
+	if cls.Index != -1 || cls.Name != "" {
+		t.Errorf("classified an empty arena as %d (%q)", cls.Index, cls.Name)
+	}
+	for i, d := range cls.Distances {
+		if d <= DefaultOptions().PaletteMatchThreshold {
+			t.Errorf("distance to %s is %d", DefaultPalette()[i].Name, d)
+		}
+	}
+}
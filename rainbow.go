@@ -1,113 +1,314 @@
 package cv
 
 import (
-	"fmt"
 	"image"
 	"image/color"
 	"math"
 )
 
-var debug = false
+type BoardState int
 
-func FindBoard(in image.Image, c color.Color, roi image.Rectangle) (left, right, bottom float32) {
-	left, right, bottom = 0.0, 1.0, 1.0
+const (
+	// No board edges, and the view doesn't look like the board either
+	BoardNotFound BoardState = iota
+	// Both edges of the board are in view
+	BoardBothEdges
+	// Only the left edge is in view, the board runs off the right of the frame
+	BoardLeftEdge
+	// Only the right edge is in view, the board runs off the left of the frame
+	BoardRightEdge
+	// No edges, but the whole view matches the board
+	BoardFillsView
+)
 
-	// Find and amplify edges
-	diff := DeltaCByCol(in)
-	minMax := MinMaxRowwise(diff)
-	ExpandContrastRowWise(diff, minMax)
-	Threshold(diff, 128)
+func (s BoardState) String() string {
+	switch s {
+	case BoardBothEdges:
+		return "both edges"
+	case BoardLeftEdge:
+		return "left edge"
+	case BoardRightEdge:
+		return "right edge"
+	case BoardFillsView:
+		return "fills view"
+	}
+	return "not found"
+}
+
+type BoardDetection struct {
+	State BoardState
+
+	// Normalised to the image width/height. All NaN if State is
+	// BoardNotFound, Bottom is NaN if it couldn't be found
+	Left, Right, Bottom float32
+	// The same in pixels, -1 where the normalised value is NaN
+	PixLeft, PixRight, PixBottom int
+
+	// Indices of the chosen blobs in the vertical line (left/right) and
+	// horizontal line (bottom) projections, -1 if not used
+	LeftBlob, RightBlob, BottomBlob int
+
+	// Proportion of rows (0-255) which agreed on each edge, 0 if the edge
+	// isn't visible
+	LeftStrength, RightStrength uint8
+
+	// AverageDeltaCROIConst along the bottom edge against the board colour.
+	// 255 if the bottom wasn't found
+	BottomScore uint8
+
+	// From 0 (no idea) to 1 (certain)
+	Confidence float32
+}
+
+// Returns a copy of diff with all rows which don't have between minBlobs and
+// maxBlobs blobs (inclusive) zeroed out
+func maskRowsByBlobs(diff *image.Gray, minBlobs, maxBlobs int) *image.Gray {
+	out := image.NewGray(diff.Bounds())
 
-	// Attempt to ignore noisy rows (likely above/below the target)
 	w, h := ImageDims(diff)
 	for y := 0; y < h; y++ {
 		row := diff.Pix[y * diff.Stride : y * diff.Stride + w]
-		blobs := FindBlobs(row)
-		if len(blobs) != 2 {
-			for x := 0; x < len(row); x++ {
-				row[x] = 0
-			}
+		if n := len(FindBlobs(row)); n >= minBlobs && n <= maxBlobs {
+			copy(out.Pix[y * out.Stride:], row)
 		}
 	}
 
+	return out
+}
+
+// Returns the blobs in the vertical line projection of diff, and the
+// proportion of rows (0-255) which had an edge within each one. Traces are
+// prefixed with name.
+func findVerticalEdges(diff *image.Gray, minBlobs, maxBlobs int, name string, opts *Options) ([]Tuple, []uint8) {
+	// Attempt to ignore noisy rows (likely above/below the target)
+	masked := maskRowsByBlobs(diff, minBlobs, maxBlobs)
+	opts.trace(name + ".masked", masked)
+
 	// Find vertical lines in the non-noisy bits
-	summed := FindVerticalLines(diff)
-	minMax = MinMaxRowwise(summed)
+	summed := FindVerticalLinesStripes(masked, opts.LineStripes)
+	opts.trace(name + ".projection", summed)
+	minMax := MinMaxRowwise(summed)
+	if minMax[0].X == minMax[0].Y {
+		return nil, nil
+	}
 	ExpandContrastRowWise(summed, minMax)
-	Threshold(summed, 128)
+	opts.trace(name + ".projection.expanded", summed)
+	opts.LineThreshold.Apply(summed)
+	opts.trace(name + ".projection.threshold", summed)
 
-	if debug {
-		fmt.Println(summed.Pix)
+	blobs := FindBlobs(summed.Pix)
+	opts.trace(name + ".blobs", blobs)
+
+	_, h := ImageDims(masked)
+	sums := SumColumns(masked)
+	strengths := make([]uint8, len(blobs))
+	for i, b := range blobs {
+		peak := 0
+		for _, v := range sums[b.First:min(b.Second + 1, len(sums))] {
+			peak = max(peak, v)
+		}
+		strengths[i] = uint8(peak * 255 / h)
 	}
+	opts.trace(name + ".strengths", strengths)
 
-	// Hopefully we're left with exactly two blobs, marking the edges
-	// TODO: Should handle 1 (one edge only) and 0 (full FoV filled) blob too
-	blobs := FindBlobs(summed.Pix)
-	scale := in.Bounds().Dx() / len(summed.Pix)
+	return blobs, strengths
+}
 
-	if debug {
-		fmt.Println("Blobs", blobs)
+// Average DeltaC between row y of in, from x0 to x1, and c
+func boardColorScore(in image.Image, c color.Color, y, x0, x1 int, m ColorMetric) uint8 {
+	if x1 <= x0 {
+		return 255
 	}
 
-	var target = Tuple{ 0, in.Bounds().Dx() }
-	if len(blobs) == 2 {
-		target = Tuple{
-			RoundUp((blobs[0].First + blobs[0].Second) * scale / 2, 2),
-			RoundUp((blobs[1].First + blobs[1].Second) * scale / 2, 2),
+	return AverageDeltaCROIConstMetric(in, y, c, image.Rect(x0, y, x1, y + 1), m)
+}
+
+// Finds the horizontal extent of the board in pixels, filling in the state,
+// blobs, strengths and confidence of det to match
+func findBoardTarget(in image.Image, c color.Color, det *BoardDetection, opts *Options) Tuple {
+	w, h := ImageDims(in)
+
+	det.LeftBlob, det.RightBlob = -1, -1
+	det.LeftStrength, det.RightStrength = 0, 0
+
+	// Find and amplify edges
+	diff := DeltaCByColMetric(in, opts.metric())
+	opts.trace("board.col_delta", diff)
+	minMax := MinMaxRowwise(diff)
+	ExpandContrastRowWise(diff, minMax)
+	opts.trace("board.col_delta.expanded", diff)
+	opts.EdgeThreshold.Apply(diff)
+	opts.trace("board.col_delta.threshold", diff)
+
+	scale := w / diff.Bounds().Dx()
+
+	// Hopefully we're left with two blobs marking the edges, and perhaps a
+	// little noise between them
+	blobs, strengths := findVerticalEdges(diff, opts.MinRowEdges, opts.MaxRowEdges, "board.both", opts)
+	if len(blobs) >= 2 && len(blobs) <= opts.MaxRowEdges {
+		last := len(blobs) - 1
+		det.State = BoardBothEdges
+		det.LeftBlob, det.RightBlob = 0, last
+		det.LeftStrength, det.RightStrength = strengths[0], strengths[last]
+		det.Confidence = (float32(strengths[0]) + float32(strengths[last])) / (2 * 255)
+		return Tuple{
+			RoundUp((blobs[0].First + blobs[0].Second) * scale / 2, opts.EdgeAlign),
+			RoundUp((blobs[last].First + blobs[last].Second) * scale / 2, opts.EdgeAlign),
+		}
+	}
+
+	// Without a colour there's no telling which side of a single edge is
+	// the board, or whether a view with no edges is all board or all wall
+	if c == nil {
+		det.State = BoardNotFound
+		det.Confidence = 0.0
+		return Tuple{ 0, w }
+	}
+
+	// Otherwise the board might be running off one side of the frame. Allow
+	// the same slack in edges per row as for both edges, and take the
+	// strongest
+	minEdges, maxEdges := max(opts.MinRowEdges - 1, 1), max(opts.MaxRowEdges - 1, 1)
+	blobs, strengths = findVerticalEdges(diff, minEdges, maxEdges, "board.single", opts)
+	if len(blobs) >= 1 && len(blobs) <= maxEdges {
+		idx := 0
+		for i, s := range strengths {
+			if s > strengths[idx] {
+				idx = i
+			}
+		}
+		edge := min(w, RoundUp((blobs[idx].First + blobs[idx].Second) * scale / 2, opts.EdgeAlign))
+		det.Confidence = float32(strengths[idx]) / 255
+
+		// The board is whichever side of the edge looks more like it
+		left := boardColorScore(in, c, h / 2, 0, edge, opts.metric())
+		right := boardColorScore(in, c, h / 2, edge, w, opts.metric())
+		if left < right {
+			det.State = BoardRightEdge
+			det.RightBlob, det.RightStrength = idx, strengths[idx]
+			return Tuple{ 0, edge }
 		}
+		det.State = BoardLeftEdge
+		det.LeftBlob, det.LeftStrength = idx, strengths[idx]
+		return Tuple{ edge, w }
+	}
+
+	// No edges at all. Either we're right up against the board, or it's
+	// nowhere to be seen
+	match := boardColorScore(in, c, h / 2, 0, w, opts.metric())
+	if match <= opts.BoardColorThreshold {
+		det.State = BoardFillsView
+		det.Confidence = 1.0 - float32(match) / 255
+		return Tuple{ 0, w }
+	}
+
+	det.State = BoardNotFound
+	det.Confidence = 0.0
+	return Tuple{ 0, w }
+}
+
+func FindBoard(in image.Image, c color.Color, roi image.Rectangle) (left, right, bottom float32) {
+	det := DetectBoard(in, c, roi, nil)
+	return det.Left, det.Right, det.Bottom
+}
+
+// If c is nil only BoardBothEdges (or BoardNotFound) can be reported, and
+// the bottom isn't looked for. opts may be nil, to use DefaultOptions
+func DetectBoard(in image.Image, c color.Color, roi image.Rectangle, opts *Options) BoardDetection {
+	opts = opts.orDefault()
+	w, h := ImageDims(in)
+	det := BoardDetection{
+		Left: 0.0,
+		Right: 1.0,
+		Bottom: 1.0,
+		PixLeft: 0,
+		PixRight: w,
+		PixBottom: h,
+		BottomBlob: -1,
+		BottomScore: 255,
 	}
 
-	if debug {
-		fmt.Println("target", target)
+	target := findBoardTarget(in, c, &det, opts)
+	opts.trace("board.state", det.State)
+	opts.trace("board.target", target)
+
+	if det.State == BoardNotFound {
+		nan := float32(math.NaN())
+		det.Left, det.Right, det.Bottom = nan, nan, nan
+		det.PixLeft, det.PixRight, det.PixBottom = -1, -1, -1
+		return det
 	}
 
-	left = float32(target.First) / float32(in.Bounds().Dx())
-	right = float32(target.Second) / float32(in.Bounds().Dx())
+	det.PixLeft, det.PixRight = target.First, target.Second
+	det.Left = float32(target.First) / float32(w)
+	det.Right = float32(target.Second) / float32(w)
 
 	// Only look for the bottom if we know what color we are after
 	if c != nil {
-		roi := image.Rect(target.First, 0, target.Second, in.Bounds().Dy())
-		diff := DeltaCByRowROI(in, roi)
-		minMax := MinMaxColwise(diff)
-		ExpandContrastColWise(diff, minMax)
-		Threshold(diff, 128)
-
-		summed := FindHorizontalLines(diff)
-		minMax = MinMaxColwise(summed)
-		ExpandContrastColWise(summed, minMax)
-		Threshold(summed, 128)
-
-		blobs := FindBlobs(summed.Pix)
-		scale := roi.Dy() / len(summed.Pix)
-
-		if len(blobs) == 0 {
-			return left, right, float32(math.NaN())
-		}
+		findBoardBottom(in, c, target, &det, opts)
+	}
 
-		avgs := make([]uint8, 0, len(blobs))
-		for _, b := range blobs {
-			avgs = append(avgs, AverageDeltaCROIConst(in, b.First * scale, c, roi))
-		}
+	return det
+}
 
-		if debug {
-			fmt.Println("Avgs:", avgs)
-		}
+// Finds the bottom edge of the board of colour c spanning target, filling
+// in the bottom fields of det and scaling its confidence to match
+func findBoardBottom(in image.Image, c color.Color, target Tuple, det *BoardDetection, opts *Options) {
+	h := in.Bounds().Dy()
 
-		min := uint8(255)
-		minIdx := -1
-		for i, m := range avgs {
-			if m < min {
-				min = m
-				minIdx = i
-			}
-		}
+	roi := image.Rect(target.First, 0, target.Second, h)
+	diff := DeltaCByRowROIMetric(in, roi, opts.metric())
+	opts.trace("board.bottom.row_delta", diff)
+	minMax := MinMaxColwise(diff)
+	ExpandContrastColWise(diff, minMax)
+	opts.trace("board.bottom.row_delta.expanded", diff)
+	opts.EdgeThreshold.Apply(diff)
+	opts.trace("board.bottom.row_delta.threshold", diff)
+
+	summed := FindHorizontalLinesStripes(diff, opts.LineStripes)
+	opts.trace("board.bottom.projection", summed)
+	minMax = MinMaxColwise(summed)
+	ExpandContrastColWise(summed, minMax)
+	opts.trace("board.bottom.projection.expanded", summed)
+	opts.LineThreshold.Apply(summed)
+	opts.trace("board.bottom.projection.threshold", summed)
+
+	blobs := FindBlobs(summed.Pix)
+	opts.trace("board.bottom.blobs", blobs)
+	scale := roi.Dy() / len(summed.Pix)
+
+	if len(blobs) == 0 {
+		// Not knowing where the bottom is makes the rest less
+		// believable too
+		det.Bottom = float32(math.NaN())
+		det.PixBottom = -1
+		det.BottomBlob = -1
+		det.BottomScore = 255
+		det.Confidence *= 0.5
+		return
+	}
+
+	avgs := make([]uint8, 0, len(blobs))
+	for _, b := range blobs {
+		avgs = append(avgs, AverageDeltaCROIConstMetric(in, roi.Min.Y + b.First * scale, c, roi, opts.metric()))
+	}
+
+	opts.trace("board.bottom.avgs", avgs)
 
-		b := blobs[minIdx]
-		if debug {
-			fmt.Println("Blob", minIdx, "at", b)
+	min := uint8(255)
+	minIdx := 0
+	for i, m := range avgs {
+		if m < min {
+			min = m
+			minIdx = i
 		}
-		bottom = float32((b.First + b.Second + 1) / 2) / float32(len(summed.Pix))
 	}
 
-	return left, right, bottom
+	b := blobs[minIdx]
+	opts.trace("board.bottom.chosen", minIdx)
+	det.Bottom = float32((b.First + b.Second + 1) / 2) / float32(len(summed.Pix))
+	det.PixBottom = int(det.Bottom * float32(h))
+	det.BottomBlob = minIdx
+	det.BottomScore = avgs[minIdx]
+	det.Confidence *= 1.0 - float32(det.BottomScore) / 255
 }
@@ -0,0 +1,396 @@
+package cv
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// Wrappers for raw camera buffers, so they can be processed without
+// conversion. None of these copy the buffer.
+
+// Wraps a packed I420 buffer (Y plane, then the Cb and Cr planes at half
+// resolution in both directions) as an *image.YCbCr
+func NewI420(buf []uint8, w, h int) (*image.YCbCr, error) {
+	cw, ch := chromaDims(w, h, image.YCbCrSubsampleRatio420)
+	ylen, clen := w * h, cw * ch
+	if len(buf) < ylen + 2 * clen {
+		return nil, fmt.Errorf("I420 buffer is %d bytes, need %d for %dx%d", len(buf), ylen + 2 * clen, w, h)
+	}
+
+	return &image.YCbCr{
+		Y: buf[:ylen:ylen],
+		Cb: buf[ylen : ylen + clen : ylen + clen],
+		Cr: buf[ylen + clen : ylen + 2 * clen : ylen + 2 * clen],
+		YStride: w,
+		CStride: cw,
+		SubsampleRatio: image.YCbCrSubsampleRatio420,
+		Rect: image.Rect(0, 0, w, h),
+	}, nil
+}
+
+// 4:2:0 with a full resolution Y plane, followed by a single plane of
+// interleaved Cb, Cr pairs at half resolution in both directions
+type NV12 struct {
+	Y, CbCr []uint8
+	// Both in bytes. Each row of CbCr has CStride / 2 chroma samples
+	YStride, CStride int
+	Rect image.Rectangle
+}
+
+// Wraps buf, which holds a w x h NV12 frame with stride bytes per row in
+// both planes. stride may be 0 if the rows are packed.
+func NewNV12(buf []uint8, w, h, stride int) (*NV12, error) {
+	if stride == 0 {
+		stride = RoundUp(w, 2)
+	}
+	if stride < RoundUp(w, 2) {
+		return nil, fmt.Errorf("NV12 stride %d too small for width %d", stride, w)
+	}
+
+	ylen, clen := stride * h, stride * ((h + 1) / 2)
+	if len(buf) < ylen + clen {
+		return nil, fmt.Errorf("NV12 buffer is %d bytes, need %d for %dx%d", len(buf), ylen + clen, w, h)
+	}
+
+	return &NV12{
+		Y: buf[:ylen],
+		CbCr: buf[ylen : ylen + clen],
+		YStride: stride,
+		CStride: stride,
+		Rect: image.Rect(0, 0, w, h),
+	}, nil
+}
+
+func (p *NV12) ColorModel() color.Model {
+	return color.YCbCrModel
+}
+
+func (p *NV12) Bounds() image.Rectangle {
+	return p.Rect
+}
+
+func (p *NV12) At(x, y int) color.Color {
+	return p.YCbCrAt(x, y)
+}
+
+func (p *NV12) YCbCrAt(x, y int) color.YCbCr {
+	if !(image.Point{ x, y }.In(p.Rect)) {
+		return color.YCbCr{}
+	}
+	yi := p.YOffset(x, y)
+	ci := p.COffset(x, y)
+	return color.YCbCr{ Y: p.Y[yi], Cb: p.CbCr[ci], Cr: p.CbCr[ci + 1] }
+}
+
+func (p *NV12) YOffset(x, y int) int {
+	return (y - p.Rect.Min.Y) * p.YStride + (x - p.Rect.Min.X)
+}
+
+// Index of the Cb sample for (x, y). The Cr sample follows it.
+func (p *NV12) COffset(x, y int) int {
+	return (y / 2 - p.Rect.Min.Y / 2) * p.CStride + (x / 2 - p.Rect.Min.X / 2) * 2
+}
+
+func (p *NV12) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.Rect)
+	if r.Empty() {
+		return &NV12{}
+	}
+
+	yi := p.YOffset(r.Min.X, r.Min.Y)
+	ci := p.COffset(r.Min.X, r.Min.Y)
+	return &NV12{
+		Y: p.Y[yi:],
+		CbCr: p.CbCr[ci:],
+		YStride: p.YStride,
+		CStride: p.CStride,
+		Rect: r,
+	}
+}
+
+// Packed 4:2:2, with each pair of pixels stored as Y0 Cb Y1 Cr
+type YUYV struct {
+	Pix []uint8
+	Stride int
+	Rect image.Rectangle
+}
+
+// Wraps buf, which holds a w x h YUYV frame with stride bytes per row.
+// stride may be 0 if the rows are packed.
+func NewYUYV(buf []uint8, w, h, stride int) (*YUYV, error) {
+	if w % 2 != 0 {
+		return nil, fmt.Errorf("YUYV width must be even, not %d", w)
+	}
+	if stride == 0 {
+		stride = w * 2
+	}
+	if stride < w * 2 {
+		return nil, fmt.Errorf("YUYV stride %d too small for width %d", stride, w)
+	}
+	if len(buf) < stride * h {
+		return nil, fmt.Errorf("YUYV buffer is %d bytes, need %d for %dx%d", len(buf), stride * h, w, h)
+	}
+
+	return &YUYV{
+		Pix: buf[:stride * h],
+		Stride: stride,
+		Rect: image.Rect(0, 0, w, h),
+	}, nil
+}
+
+func (p *YUYV) ColorModel() color.Model {
+	return color.YCbCrModel
+}
+
+func (p *YUYV) Bounds() image.Rectangle {
+	return p.Rect
+}
+
+func (p *YUYV) At(x, y int) color.Color {
+	return p.YCbCrAt(x, y)
+}
+
+func (p *YUYV) YCbCrAt(x, y int) color.YCbCr {
+	if !(image.Point{ x, y }.In(p.Rect)) {
+		return color.YCbCr{}
+	}
+	ci := p.COffset(x, y)
+	return color.YCbCr{ Y: p.Pix[p.YOffset(x, y)], Cb: p.Pix[ci], Cr: p.Pix[ci + 2] }
+}
+
+// Pix starts on the pair containing Rect.Min
+func (p *YUYV) pairX() int {
+	return p.Rect.Min.X &^ 1
+}
+
+func (p *YUYV) YOffset(x, y int) int {
+	return (y - p.Rect.Min.Y) * p.Stride + (x - p.pairX()) * 2
+}
+
+// Index of the Cb sample for (x, y). The Cr sample is 2 bytes after it.
+func (p *YUYV) COffset(x, y int) int {
+	return (y - p.Rect.Min.Y) * p.Stride + (x &^ 1 - p.pairX()) * 2 + 1
+}
+
+func (p *YUYV) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.Rect)
+	if r.Empty() {
+		return &YUYV{}
+	}
+
+	i := (r.Min.Y - p.Rect.Min.Y) * p.Stride + (r.Min.X &^ 1 - p.pairX()) * 2
+	return &YUYV{
+		Pix: p.Pix[i:],
+		Stride: p.Stride,
+		Rect: r,
+	}
+}
+
+// A view of any of the YCbCr-like image types, for the fast paths. Chroma
+// samples for (x, y) are cb[cOffset(x, y)] and cr[cOffset(x, y)].
+type yuvPlanes struct {
+	y, cb, cr []uint8
+	yStride, cStride int
+	// Distance between horizontally adjacent luma/chroma samples
+	yStep, cStep int
+	// Where the planes start
+	min image.Point
+	// How many luma samples each chroma sample covers
+	hsub, vsub int
+}
+
+func yuvPlanesOf(in image.Image) (yuvPlanes, bool) {
+	switch v := in.(type) {
+	case *image.NYCbCrA:
+		// Alpha is ignored, our cameras don't have any
+		return yuvPlanesOf(&v.YCbCr)
+	case *image.YCbCr:
+		hsub, vsub := SubsampleFactors(v.SubsampleRatio)
+		return yuvPlanes{
+			y: v.Y, cb: v.Cb, cr: v.Cr,
+			yStride: v.YStride, cStride: v.CStride,
+			yStep: 1, cStep: 1,
+			min: v.Rect.Min,
+			hsub: hsub, vsub: vsub,
+		}, true
+	case *NV12:
+		if len(v.CbCr) < 2 {
+			break
+		}
+		return yuvPlanes{
+			y: v.Y, cb: v.CbCr, cr: v.CbCr[1:],
+			yStride: v.YStride, cStride: v.CStride,
+			yStep: 1, cStep: 2,
+			min: v.Rect.Min,
+			hsub: 2, vsub: 2,
+		}, true
+	case *YUYV:
+		if len(v.Pix) < 4 {
+			break
+		}
+		return yuvPlanes{
+			y: v.Pix, cb: v.Pix[1:], cr: v.Pix[3:],
+			yStride: v.Stride, cStride: v.Stride,
+			yStep: 2, cStep: 4,
+			min: image.Pt(v.pairX(), v.Rect.Min.Y),
+			hsub: 2, vsub: 1,
+		}, true
+	}
+	return yuvPlanes{}, false
+}
+
+// The same as the images' own YOffset and COffset
+func (p *yuvPlanes) yOffset(x, y int) int {
+	return (y - p.min.Y) * p.yStride + (x - p.min.X) * p.yStep
+}
+
+func (p *yuvPlanes) cOffset(x, y int) int {
+	return (y / p.vsub - p.min.Y / p.vsub) * p.cStride + (x / p.hsub - p.min.X / p.hsub) * p.cStep
+}
+
+func (p *yuvPlanes) at(yoff, coff int) color.YCbCr {
+	return color.YCbCr{ Y: p.y[yoff], Cb: p.cb[coff], Cr: p.cr[coff] }
+}
+
+// Where the chroma sample covering luma column x ends. Chroma samples are
+// sited on multiples of hsub (and vsub) in image coordinates, as in
+// image.YCbCr.COffset, not relative to the image or ROI origin.
+func (p *yuvPlanes) nextChromaCol(x int) int {
+	return (x / p.hsub + 1) * p.hsub
+}
+
+// Luma rows in the same chroma row can only differ in luma, so this moves one
+// of them to the nearest row of a neighbouring chroma row, within bounds
+func (p *yuvPlanes) spreadRows(rowA, rowB int, bounds image.Rectangle) (int, int) {
+	if p.vsub == 1 || rowA / p.vsub != rowB / p.vsub {
+		return rowA, rowB
+	}
+
+	if first := rowA / p.vsub * p.vsub; first > bounds.Min.Y {
+		rowA = first - 1
+	} else if next := rowB / p.vsub * p.vsub + p.vsub; next < bounds.Max.Y {
+		rowB = next
+	}
+	return rowA, rowB
+}
+
+// Sum of m's differences between luma rows rowA and rowB, from x0 to x1
+// (exclusive). Each chroma sample is compared once, and counts for as many of
+// the pixels as it covers.
+func (p *yuvPlanes) sumRowDeltas(x0, x1, rowA, rowB int, m ColorMetric) int {
+	yoffA, coffA := p.yOffset(x0, rowA), p.cOffset(x0, rowA)
+	yoffB, coffB := p.yOffset(x0, rowB), p.cOffset(x0, rowB)
+
+	total := 0
+	for x := x0; x < x1; {
+		next := min(p.nextChromaCol(x), x1)
+		diff := m.DeltaYCbCr(p.at(yoffA, coffA), p.at(yoffB, coffB))
+		total += int(diff) * (next - x)
+
+		yoffA, yoffB = yoffA + (next - x) * p.yStep, yoffB + (next - x) * p.yStep
+		coffA, coffB = coffA + p.cStep, coffB + p.cStep
+		x = next
+	}
+	return total
+}
+
+// As sumRowDeltas, against the constant colour d
+func (p *yuvPlanes) sumConstDeltas(x0, x1, row int, d color.Color, m ColorMetric) int {
+	yoff, coff := p.yOffset(x0, row), p.cOffset(x0, row)
+
+	total := 0
+	for x := x0; x < x1; {
+		next := min(p.nextChromaCol(x), x1)
+		diff := m.Delta(p.at(yoff, coff), d)
+		total += int(diff) * (next - x)
+
+		yoff += (next - x) * p.yStep
+		coff += p.cStep
+		x = next
+	}
+	return total
+}
+
+// Direct access to the pixels of the RGB and grey image types, for the fast
+// paths
+type rgbPixels struct {
+	pix []uint8
+	stride int
+	// Bytes per pixel
+	step int
+	rect image.Rectangle
+}
+
+func rgbPixelsOf(in image.Image) (rgbPixels, bool) {
+	switch v := in.(type) {
+	case *image.Gray:
+		return rgbPixels{ pix: v.Pix, stride: v.Stride, step: 1, rect: v.Rect }, true
+	case *image.RGBA:
+		return rgbPixels{ pix: v.Pix, stride: v.Stride, step: 4, rect: v.Rect }, true
+	case *image.NRGBA:
+		return rgbPixels{ pix: v.Pix, stride: v.Stride, step: 4, rect: v.Rect }, true
+	}
+	return rgbPixels{}, false
+}
+
+func (p *rgbPixels) offset(x, y int) int {
+	return (y - p.rect.Min.Y) * p.stride + (x - p.rect.Min.X) * p.step
+}
+
+// The colour at off, if it's opaque. For opaque pixels RGBA and NRGBA are the
+// same, the rest are left to the slow path.
+func (p *rgbPixels) at(off int) (color.NRGBA, bool) {
+	if p.step == 1 {
+		g := p.pix[off]
+		return color.NRGBA{ R: g, G: g, B: g, A: 255 }, true
+	}
+	pix := p.pix[off:off + 4:off + 4]
+	return color.NRGBA{ R: pix[0], G: pix[1], B: pix[2], A: 255 }, pix[3] == 255
+}
+
+// m's difference between pixels (x0, y0) and (x1, y1) of in, which p is from
+func (p *rgbPixels) delta(in image.Image, m RGBColorMetric, x0, y0, x1, y1 int) uint8 {
+	a, aok := p.at(p.offset(x0, y0))
+	b, bok := p.at(p.offset(x1, y1))
+	if aok && bok {
+		return m.DeltaRGB(a, b)
+	}
+	return m.Delta(in.At(x0, y0), in.At(x1, y1))
+}
+
+// m's difference between pixel (x, y) of in and d. dn is d as m would
+// convert it, and is only used if dok (see constNRGBA).
+func (p *rgbPixels) deltaConst(in image.Image, m RGBColorMetric, x, y int, d color.Color, dn color.NRGBA, dok bool) uint8 {
+	if a, ok := p.at(p.offset(x, y)); ok && dok {
+		return m.DeltaRGB(a, dn)
+	}
+	return m.Delta(in.At(x, y), d)
+}
+
+// c converted to NRGBA the way m does it, if c is opaque and that's known.
+// The other metrics convert it themselves, e.g. straight to YCbCr.
+func constNRGBA(m ColorMetric, c color.Color) (color.NRGBA, bool) {
+	if n, ok := c.(color.NRGBA); ok {
+		return n, n.A == 255 && (m == DefaultMetric || m == RGBMetric)
+	}
+
+	switch m {
+	case DefaultMetric:
+		// As DeltaC
+		r, g, b, a := c.RGBA()
+		if a != 0xffff {
+			return color.NRGBA{}, false
+		}
+		return color.NRGBA{
+			R: uint8(float64(r) * 255.0 / float64(a)),
+			G: uint8(float64(g) * 255.0 / float64(a)),
+			B: uint8(float64(b) * 255.0 / float64(a)),
+			A: 255,
+		}, true
+	case RGBMetric:
+		n := asNRGBA(c)
+		return n, n.A == 255
+	}
+	return color.NRGBA{}, false
+}
@@ -0,0 +1,233 @@
+package cv
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+// Compares every pixel of got with want, along with what got's offsets and
+// yuvPlanesOf point at. Outside the bounds, At should be zero.
+func checkRawFrame(t *testing.T, name string, got image.Image, want *image.YCbCr,
+		yAt func(x, y int) uint8, cAt func(x, y int) (uint8, uint8)) {
+	t.Helper()
+	if got.Bounds() != want.Bounds() {
+		t.Fatalf("%s: bounds %v, want %v", name, got.Bounds(), want.Bounds())
+	}
+
+	planes, ok := yuvPlanesOf(got)
+	if !ok {
+		t.Fatalf("%s: no fast path", name)
+	}
+
+	b := want.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			w := want.YCbCrAt(x, y)
+			if c := got.At(x, y); c != w {
+				t.Fatalf("%s: At(%d, %d) = %v, want %v", name, x, y, c, w)
+			}
+
+			if v := yAt(x, y); v != w.Y {
+				t.Fatalf("%s: YOffset(%d, %d) points at %d, want %d", name, x, y, v, w.Y)
+			}
+			if cb, cr := cAt(x, y); cb != w.Cb || cr != w.Cr {
+				t.Fatalf("%s: COffset(%d, %d) points at %d, %d, want %d, %d", name, x, y, cb, cr, w.Cb, w.Cr)
+			}
+
+			if v := planes.at(planes.yOffset(x, y), planes.cOffset(x, y)); v != w {
+				t.Fatalf("%s: yuvPlanes at (%d, %d) = %v, want %v", name, x, y, v, w)
+			}
+		}
+	}
+
+	outside := []image.Point{
+		{ b.Min.X - 1, b.Min.Y }, { b.Min.X, b.Min.Y - 1 }, { b.Max.X, b.Min.Y }, { b.Min.X, b.Max.Y },
+	}
+	for _, p := range outside {
+		if c := got.At(p.X, p.Y); c != (color.YCbCr{}) {
+			t.Errorf("%s: At(%v) outside the bounds = %v", name, p, c)
+		}
+	}
+}
+
+// Packs ref's planes into an NV12 buffer, with junk in the padding at the end
+// of each row
+func packNV12(ref *image.YCbCr, stride int) []uint8 {
+	w, h := ImageDims(ref)
+	cw, ch := chromaDims(w, h, image.YCbCrSubsampleRatio420)
+
+	buf := make([]uint8, stride * (h + ch))
+	for i := range buf {
+		buf[i] = 0xee
+	}
+	for y := 0; y < h; y++ {
+		copy(buf[y * stride:], ref.Y[y * ref.YStride : y * ref.YStride + w])
+	}
+	for y := 0; y < ch; y++ {
+		row := buf[(h + y) * stride:]
+		for x := 0; x < cw; x++ {
+			row[2 * x] = ref.Cb[y * ref.CStride + x]
+			row[2 * x + 1] = ref.Cr[y * ref.CStride + x]
+		}
+	}
+	return buf
+}
+
+func packYUYV(ref *image.YCbCr, stride int) []uint8 {
+	w, h := ImageDims(ref)
+
+	buf := make([]uint8, stride * h)
+	for i := range buf {
+		buf[i] = 0xee
+	}
+	for y := 0; y < h; y++ {
+		row := buf[y * stride:]
+		for x := 0; x < w; x += 2 {
+			c := ref.COffset(x, y)
+			row[2 * x] = ref.Y[ref.YOffset(x, y)]
+			row[2 * x + 1] = ref.Cb[c]
+			row[2 * x + 2] = ref.Y[ref.YOffset(x + 1, y)]
+			row[2 * x + 3] = ref.Cr[c]
+		}
+	}
+	return buf
+}
+
+// Sub-images starting on odd columns and rows, which split chroma samples,
+// and a sub-image of one of those
+var rawSubRects = []image.Rectangle{
+	image.Rect(3, 1, 12, 8),
+	image.Rect(2, 2, 5, 3),
+	image.Rect(1, 3, 2, 4),
+}
+
+func TestNV12(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	w, h := 13, 9
+	ref := noisyYCbCr(rnd, image.Rect(0, 0, w, h), image.YCbCrSubsampleRatio420)
+
+	for _, stride := range []int{ 0, 14, 20 } {
+		s := stride
+		if s == 0 {
+			s = 14
+		}
+		img, err := NewNV12(packNV12(ref, s), w, h, stride)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		check := func(name string, img *NV12, want *image.YCbCr) {
+			t.Helper()
+			checkRawFrame(t, name, img, want,
+				func(x, y int) uint8 { return img.Y[img.YOffset(x, y)] },
+				func(x, y int) (uint8, uint8) {
+					c := img.COffset(x, y)
+					return img.CbCr[c], img.CbCr[c + 1]
+				})
+		}
+		check("NV12", img, ref)
+
+		for _, r := range rawSubRects {
+			sub := img.SubImage(r).(*NV12)
+			want := ref.SubImage(r).(*image.YCbCr)
+			check("NV12 " + r.String(), sub, want)
+
+			// And again from the sub-image
+			inner := r.Inset(1)
+			if !inner.Empty() {
+				check("NV12 " + r.String() + " " + inner.String(),
+					sub.SubImage(inner).(*NV12), want.SubImage(inner).(*image.YCbCr))
+			}
+		}
+
+		if sub := img.SubImage(image.Rect(20, 20, 30, 30)); !sub.Bounds().Empty() {
+			t.Errorf("NV12 SubImage outside the frame is %v", sub.Bounds())
+		}
+	}
+}
+
+func TestYUYV(t *testing.T) {
+	rnd := rand.New(rand.NewSource(2))
+	w, h := 14, 9
+	ref := noisyYCbCr(rnd, image.Rect(0, 0, w, h), image.YCbCrSubsampleRatio422)
+
+	for _, stride := range []int{ 0, 28, 32 } {
+		s := stride
+		if s == 0 {
+			s = 2 * w
+		}
+		img, err := NewYUYV(packYUYV(ref, s), w, h, stride)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		check := func(name string, img *YUYV, want *image.YCbCr) {
+			t.Helper()
+			checkRawFrame(t, name, img, want,
+				func(x, y int) uint8 { return img.Pix[img.YOffset(x, y)] },
+				func(x, y int) (uint8, uint8) {
+					c := img.COffset(x, y)
+					return img.Pix[c], img.Pix[c + 2]
+				})
+		}
+		check("YUYV", img, ref)
+
+		for _, r := range rawSubRects {
+			sub := img.SubImage(r).(*YUYV)
+			want := ref.SubImage(r).(*image.YCbCr)
+			check("YUYV " + r.String(), sub, want)
+
+			inner := r.Inset(1)
+			if !inner.Empty() {
+				check("YUYV " + r.String() + " " + inner.String(),
+					sub.SubImage(inner).(*YUYV), want.SubImage(inner).(*image.YCbCr))
+			}
+		}
+
+		if sub := img.SubImage(image.Rect(20, 20, 30, 30)); !sub.Bounds().Empty() {
+			t.Errorf("YUYV SubImage outside the frame is %v", sub.Bounds())
+		}
+	}
+}
+
+func TestI420(t *testing.T) {
+	rnd := rand.New(rand.NewSource(3))
+	w, h := 13, 9
+	ref := noisyYCbCr(rnd, image.Rect(0, 0, w, h), image.YCbCrSubsampleRatio420)
+
+	cw, ch := chromaDims(w, h, image.YCbCrSubsampleRatio420)
+	buf := make([]uint8, 0, w * h + 2 * cw * ch)
+	buf = append(buf, ref.Y[:w * h]...)
+	buf = append(buf, ref.Cb[:cw * ch]...)
+	buf = append(buf, ref.Cr[:cw * ch]...)
+
+	img, err := NewI420(buf, w, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSameYCbCr(t, "I420", img, ref)
+
+	if _, err := NewI420(buf[:len(buf) - 1], w, h); err == nil {
+		t.Errorf("I420 accepted a short buffer")
+	}
+}
+
+func TestRawFrameErrors(t *testing.T) {
+	if _, err := NewNV12(make([]uint8, 14 * 9 + 14 * 5 - 1), 13, 9, 0); err == nil {
+		t.Errorf("NV12 accepted a short buffer")
+	}
+	if _, err := NewNV12(make([]uint8, 1000), 13, 9, 13); err == nil {
+		t.Errorf("NV12 accepted a stride too small for the interleaved chroma")
+	}
+	if _, err := NewYUYV(make([]uint8, 1000), 13, 9, 0); err == nil {
+		t.Errorf("YUYV accepted an odd width")
+	}
+	if _, err := NewYUYV(make([]uint8, 1000), 14, 9, 27); err == nil {
+		t.Errorf("YUYV accepted a stride too small")
+	}
+	if _, err := NewYUYV(make([]uint8, 28 * 9 - 1), 14, 9, 0); err == nil {
+		t.Errorf("YUYV accepted a short buffer")
+	}
+}
@@ -0,0 +1,280 @@
+package cv
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+)
+
+// Synthetic arena frames with known ground truth, for exercising the
+// detectors without real footage.
+
+// A board standing on the floor, running up off the top of the frame.
+// Everything is normalised to the frame size, and may be outside [0, 1] for
+// boards which are only partly in view.
+type SceneBoard struct {
+	Left, Right float64
+	Bottom float64
+	Color color.Color
+}
+
+type Scene struct {
+	Width, Height int
+	SubsampleRatio image.YCbCrSubsampleRatio
+
+	Wall, Floor color.Color
+	Boards []SceneBoard
+
+	// Normalised row where the wall meets the floor, at the centre of the
+	// frame
+	Horizon float64
+	// Camera roll, clockwise in radians. Everything is rotated about the
+	// centre of the frame
+	Tilt float64
+
+	// Standard deviation of the Gaussian noise added to each plane, in
+	// 8-bit levels
+	Noise float64
+	// Radius of the box blur applied before subsampling, in pixels
+	Blur int
+	// Luma is scaled by 1 - Gradient at the left edge, up to 1 + Gradient at
+	// the right, to mimic uneven lighting
+	Gradient float64
+
+	// Seeds the noise, so the same Scene always renders the same frame
+	Seed int64
+}
+
+type BoardTruth struct {
+	// Which edges are in view, in the same terms as BoardDetection
+	State BoardState
+	// Clipped to the frame. The pixel values are the first column inside
+	// the board, the first column past it and the first floor row below it
+	Left, Right, Bottom float32
+	PixLeft, PixRight, PixBottom int
+}
+
+type SceneTruth struct {
+	// One for each of Scene.Boards, in the same order. With Tilt, these are
+	// measured before rotation so only hold along the centre of the frame
+	Boards []BoardTruth
+	// Normalised horizon row at the centre of the frame, as returned by
+	// FindHorizon
+	Horizon float32
+	Line HorizonLine
+}
+
+// A 320x240 4:2:0 frame with a single red board in the middle
+func DefaultScene() *Scene {
+	return &Scene{
+		Width: 320,
+		Height: 240,
+		SubsampleRatio: image.YCbCrSubsampleRatio420,
+		Wall: color.NRGBA{ 0xc8, 0xc8, 0xc8, 0xff },
+		Floor: color.NRGBA{ 0x30, 0x30, 0x38, 0xff },
+		Boards: []SceneBoard{
+			{ Left: 0.35, Right: 0.65, Bottom: 0.7, Color: color.NRGBA{ 0xc0, 0x20, 0x20, 0xff } },
+		},
+		Horizon: 0.75,
+	}
+}
+
+func toYCbCr(c color.Color) color.YCbCr {
+	if v, ok := c.(color.YCbCr); ok {
+		return v
+	}
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	y, cb, cr := color.RGBToYCbCr(n.R, n.G, n.B)
+	return color.YCbCr{ Y: y, Cb: cb, Cr: cr }
+}
+
+// Column of the first pixel whose centre is at or past normalised position v
+func pixEdge(v float64, size int) int {
+	p := int(math.Ceil(v * float64(size) - 0.5))
+	return min(max(p, 0), size)
+}
+
+func (s *Scene) truth() SceneTruth {
+	w, h := s.Width, s.Height
+
+	// Rolling about the centre of the frame stretches the horizon's
+	// distance from the centre row by 1/cos(Tilt)
+	centre := 0.5 + (s.Horizon - 0.5) / math.Cos(s.Tilt)
+	t := SceneTruth{
+		Boards: make([]BoardTruth, len(s.Boards)),
+		Horizon: float32(pixEdge(centre, h)) / float32(h),
+	}
+
+	for i, b := range s.Boards {
+		bt := BoardTruth{
+			PixLeft: pixEdge(b.Left, w),
+			PixRight: pixEdge(b.Right, w),
+			PixBottom: pixEdge(b.Bottom, h),
+		}
+		bt.Left = float32(bt.PixLeft) / float32(w)
+		bt.Right = float32(bt.PixRight) / float32(w)
+		bt.Bottom = float32(bt.PixBottom) / float32(h)
+
+		leftIn := bt.PixLeft > 0 && bt.PixLeft < w
+		rightIn := bt.PixRight > 0 && bt.PixRight < w
+		switch {
+		case bt.PixRight <= bt.PixLeft || bt.PixBottom == 0:
+			bt.State = BoardNotFound
+		case leftIn && rightIn:
+			bt.State = BoardBothEdges
+		case leftIn:
+			bt.State = BoardLeftEdge
+		case rightIn:
+			bt.State = BoardRightEdge
+		default:
+			bt.State = BoardFillsView
+		}
+
+		t.Boards[i] = bt
+	}
+
+	slope := math.Tan(s.Tilt) * float64(w) / float64(h)
+	t.Line = HorizonLine{
+		Slope: float32(slope),
+		Intercept: float32(centre - slope * 0.5),
+		Angle: float32(s.Tilt),
+		InlierRatio: 1,
+	}
+
+	return t
+}
+
+// The colour of the unrotated scene at pixel centre (x, y)
+func (s *Scene) colorAt(x, y float64, wall, floor color.YCbCr, boards []color.YCbCr) color.YCbCr {
+	nx, ny := x / float64(s.Width), y / float64(s.Height)
+
+	// Later boards are in front
+	for i := len(s.Boards) - 1; i >= 0; i-- {
+		b := s.Boards[i]
+		if nx >= b.Left && nx < b.Right && ny < b.Bottom {
+			return boards[i]
+		}
+	}
+
+	if ny < s.Horizon {
+		return wall
+	}
+	return floor
+}
+
+// Box blur of a w x h plane, in-place
+func boxBlur(plane []float64, w, h, r int) {
+	tmp := make([]float64, len(plane))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sum, n := 0.0, 0
+			for i := max(0, x - r); i < min(w, x + r + 1); i++ {
+				sum += plane[y * w + i]
+				n++
+			}
+			tmp[y * w + x] = sum / float64(n)
+		}
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sum, n := 0.0, 0
+			for j := max(0, y - r); j < min(h, y + r + 1); j++ {
+				sum += tmp[j * w + x]
+				n++
+			}
+			plane[y * w + x] = sum / float64(n)
+		}
+	}
+}
+
+func clamp8(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, math.Floor(v + 0.5))))
+}
+
+// Renders the scene, and returns it along with where everything should be
+// found
+func (s *Scene) Render() (*image.YCbCr, SceneTruth) {
+	w, h := s.Width, s.Height
+
+	wall, floor := toYCbCr(s.Wall), toYCbCr(s.Floor)
+	boards := make([]color.YCbCr, len(s.Boards))
+	for i, b := range s.Boards {
+		boards[i] = toYCbCr(b.Color)
+	}
+
+	// Render at full resolution in all planes, then subsample
+	planes := [3][]float64{
+		make([]float64, w * h),
+		make([]float64, w * h),
+		make([]float64, w * h),
+	}
+
+	cx, cy := float64(w) / 2, float64(h) / 2
+	sin, cos := math.Sincos(-s.Tilt)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			// Find where this pixel came from before the camera rolled
+			px, py := float64(x) + 0.5 - cx, float64(y) + 0.5 - cy
+			sx := px * cos - py * sin + cx
+			sy := px * sin + py * cos + cy
+
+			c := s.colorAt(sx, sy, wall, floor, boards)
+
+			light := 1.0
+			if w > 1 {
+				light += s.Gradient * (2 * float64(x) / float64(w - 1) - 1)
+			}
+
+			planes[0][y * w + x] = float64(c.Y) * light
+			planes[1][y * w + x] = float64(c.Cb)
+			planes[2][y * w + x] = float64(c.Cr)
+		}
+	}
+
+	if s.Blur > 0 {
+		for _, p := range planes {
+			boxBlur(p, w, h, s.Blur)
+		}
+	}
+
+	if s.Noise > 0 {
+		rnd := rand.New(rand.NewSource(s.Seed))
+		for _, p := range planes {
+			for i := range p {
+				p[i] += rnd.NormFloat64() * s.Noise
+			}
+		}
+	}
+
+	img := image.NewYCbCr(image.Rect(0, 0, w, h), s.SubsampleRatio)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Y[img.YOffset(x, y)] = clamp8(planes[0][y * w + x])
+		}
+	}
+
+	// Each chroma sample is the mean of the pixels it covers
+	hsub, vsub := SubsampleFactors(s.SubsampleRatio)
+	cw, ch := chromaDims(w, h, s.SubsampleRatio)
+	for j := 0; j < ch; j++ {
+		for i := 0; i < cw; i++ {
+			var cb, cr float64
+			n := 0
+			for y := j * vsub; y < min(h, (j + 1) * vsub); y++ {
+				for x := i * hsub; x < min(w, (i + 1) * hsub); x++ {
+					cb += planes[1][y * w + x]
+					cr += planes[2][y * w + x]
+					n++
+				}
+			}
+			off := img.COffset(i * hsub, j * vsub)
+			img.Cb[off] = clamp8(cb / float64(n))
+			img.Cr[off] = clamp8(cr / float64(n))
+		}
+	}
+
+	return img, s.truth()
+}
@@ -0,0 +1,115 @@
+package cv
+
+import (
+	"image"
+	"testing"
+)
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// The truth line should follow the wall/floor boundary actually rendered,
+// even well away from the centre row
+func TestSceneTruthLine(t *testing.T) {
+	s := DefaultScene()
+	s.Boards = nil
+	s.Tilt = 0.3
+	s.Horizon = 0.85
+	img, truth := s.Render()
+	w, h := ImageDims(img)
+
+	wall, floor := toYCbCr(s.Wall), toYCbCr(s.Floor)
+	mid := (int(wall.Y) + int(floor.Y)) / 2
+	for x := w / 4; x < w * 3 / 4; x += 8 {
+		row := -1
+		for y := 0; y < h; y++ {
+			if int(img.Y[img.YOffset(x, y)]) < mid {
+				row = y
+				break
+			}
+		}
+
+		want := truth.Line.At((float32(x) + 0.5) / float32(w)) * float32(h)
+		if want < 1 || want > float32(h - 1) {
+			continue
+		}
+		if abs32(float32(row) - want) > 1 {
+			t.Errorf("column %d: boundary at row %d, truth says %.1f", x, row, want)
+		}
+	}
+}
+
+func TestSceneDetectors(t *testing.T) {
+	variants := []struct {
+		name string
+		edit func(s *Scene)
+	}{
+		{ "default", func(s *Scene) {} },
+		{ "422", func(s *Scene) { s.SubsampleRatio = image.YCbCrSubsampleRatio422 } },
+		{ "444", func(s *Scene) { s.SubsampleRatio = image.YCbCrSubsampleRatio444 } },
+		{ "noisy", func(s *Scene) { s.Noise = 3; s.Blur = 1; s.Gradient = 0.1; s.Seed = 1 } },
+		{ "off left", func(s *Scene) { s.Boards[0].Left, s.Boards[0].Right = -0.2, 0.4 } },
+		{ "off right", func(s *Scene) { s.Boards[0].Left, s.Boards[0].Right = 0.6, 1.2 } },
+		{ "fills view", func(s *Scene) { s.Boards[0].Left, s.Boards[0].Right = -0.1, 1.1 } },
+		{ "no board", func(s *Scene) { s.Boards = nil } },
+		{ "tilted", func(s *Scene) { s.Tilt, s.Horizon, s.Boards[0].Bottom = 0.1, 0.85, 0.6 } },
+		{ "tilted left", func(s *Scene) { s.Tilt, s.Horizon, s.Boards[0].Bottom = -0.15, 0.8, 0.55 } },
+	}
+
+	for _, v := range variants {
+		s := DefaultScene()
+		boardColor := s.Boards[0].Color
+		v.edit(s)
+		img, truth := s.Render()
+		_, h := ImageDims(img)
+
+		if hz := FindHorizon(img); abs32(hz - truth.Horizon) > 0.02 {
+			t.Errorf("%s: horizon %.3f, want %.3f", v.name, hz, truth.Horizon)
+		}
+
+		line := FindHorizonLine(img, nil)
+		if abs32(line.Slope - truth.Line.Slope) > 0.01 || abs32(line.Intercept - truth.Line.Intercept) > 0.01 {
+			t.Errorf("%s: horizon line %.3fx + %.3f, want %.3fx + %.3f", v.name,
+				line.Slope, line.Intercept, truth.Line.Slope, truth.Line.Intercept)
+		}
+
+		det := DetectBoard(img, boardColor, image.Rectangle{}, nil)
+		if len(truth.Boards) == 0 {
+			if det.State != BoardNotFound {
+				t.Errorf("%s: found a board (%v) where there isn't one", v.name, det.State)
+			}
+			continue
+		}
+
+		// The board truth is from before the camera rolled, so only holds
+		// near the centre of a tilted frame
+		tol := 4
+		if s.Tilt != 0 {
+			tol = 12
+		}
+
+		bt := truth.Boards[0]
+		if det.State != bt.State {
+			t.Errorf("%s: board state %v, want %v", v.name, det.State, bt.State)
+			continue
+		}
+		if absInt(det.PixLeft - bt.PixLeft) > tol || absInt(det.PixRight - bt.PixRight) > tol {
+			t.Errorf("%s: board from %d to %d, want %d to %d", v.name,
+				det.PixLeft, det.PixRight, bt.PixLeft, bt.PixRight)
+		}
+		if bt.PixBottom < h && absInt(det.PixBottom - bt.PixBottom) > tol {
+			t.Errorf("%s: board bottom %d, want %d", v.name, det.PixBottom, bt.PixBottom)
+		}
+
+		// FindBoard is the same, with the default options
+		left, right, bottom := FindBoard(img, boardColor, image.Rectangle{})
+		if left != det.Left || right != det.Right || bottom != det.Bottom {
+			t.Errorf("%s: FindBoard %v %v %v, DetectBoard %v %v %v", v.name,
+				left, right, bottom, det.Left, det.Right, det.Bottom)
+		}
+	}
+}
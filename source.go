@@ -0,0 +1,239 @@
+package cv
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Frame struct {
+	Image image.Image
+	// Since the start of the stream
+	Timestamp time.Duration
+	// Counts up from 0
+	Seq int
+}
+
+// A stream of frames, from a recording, a camera or a generator
+type FrameSource interface {
+	// Returns io.EOF once there are no more frames. Any other error is for
+	// a frame which couldn't be read, and the next call carries on after it.
+	Next() (Frame, error)
+	Close() error
+}
+
+// Timestamp of frame seq at num / den frames per second. 0 if the rate
+// isn't known
+func frameTime(seq, num, den int) time.Duration {
+	if num <= 0 || den <= 0 {
+		return 0
+	}
+	return time.Duration(int64(seq) * int64(den) * int64(time.Second) / int64(num))
+}
+
+type dirSource struct {
+	dir string
+	names []string
+	period time.Duration
+	seq int
+	start time.Time
+}
+
+// Reads the JPEG and PNG files in dir, in name order. Frames are period
+// apart, or if period is 0 their timestamps come from the files'
+// modification times, relative to the oldest.
+func NewDirSource(dir string, period time.Duration) (FrameSource, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	var start time.Time
+	for _, fi := range files {
+		switch strings.ToLower(filepath.Ext(fi.Name())) {
+		case ".jpg", ".jpeg", ".png":
+			names = append(names, fi.Name())
+			// Name order needn't be time order
+			if start.IsZero() || fi.ModTime().Before(start) {
+				start = fi.ModTime()
+			}
+		}
+	}
+	sort.Strings(names)
+
+	return &dirSource{ dir: dir, names: names, period: period, start: start }, nil
+}
+
+// A file which can't be read gives an error, with its sequence number used
+// up, and the next call moves on to the one after it
+func (s *dirSource) Next() (Frame, error) {
+	if s.seq >= len(s.names) {
+		return Frame{}, io.EOF
+	}
+	seq := s.seq
+	s.seq++
+	path := filepath.Join(s.dir, s.names[seq])
+
+	f, err := os.Open(path)
+	if err != nil {
+		return Frame{}, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return Frame{}, fmt.Errorf("%s: %v", path, err)
+	}
+
+	frame := Frame{ Image: img, Seq: seq }
+	if s.period != 0 {
+		frame.Timestamp = time.Duration(seq) * s.period
+	} else if fi, err := f.Stat(); err == nil {
+		frame.Timestamp = fi.ModTime().Sub(s.start)
+	}
+
+	return frame, nil
+}
+
+func (s *dirSource) Close() error {
+	return nil
+}
+
+type y4mSource struct {
+	f *os.File
+	r *Y4MReader
+	seq int
+}
+
+// Reads a YUV4MPEG2 file. Timestamps come from its frame rate, and are all
+// 0 if it doesn't have one.
+func NewY4MSource(path string) (FrameSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := NewY4MReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return &y4mSource{ f: f, r: r }, nil
+}
+
+func (s *y4mSource) Next() (Frame, error) {
+	img, err := s.r.ReadFrame()
+	if err != nil {
+		return Frame{}, err
+	}
+
+	frame := Frame{
+		Image: img,
+		Timestamp: frameTime(s.seq, s.r.FrameRateNum, s.r.FrameRateDen),
+		Seq: s.seq,
+	}
+	s.seq++
+	return frame, nil
+}
+
+func (s *y4mSource) Close() error {
+	return s.f.Close()
+}
+
+type i420Source struct {
+	f *os.File
+	w, h int
+	period time.Duration
+	frameSize int
+	seq int
+}
+
+// Reads a file of back-to-back packed I420 frames, with no headers, each
+// w x h. Frames are period apart.
+func NewI420Source(path string, w, h int, period time.Duration) (FrameSource, error) {
+	if w <= 0 || h <= 0 {
+		return nil, fmt.Errorf("bad frame size %dx%d", w, h)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cw, ch := chromaDims(w, h, image.YCbCrSubsampleRatio420)
+	return &i420Source{
+		f: f,
+		w: w, h: h,
+		period: period,
+		frameSize: w * h + 2 * cw * ch,
+	}, nil
+}
+
+func (s *i420Source) Next() (Frame, error) {
+	// A new buffer each time, as the frame wraps it rather than copying
+	buf := make([]uint8, s.frameSize)
+	if _, err := io.ReadFull(s.f, buf); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return Frame{}, fmt.Errorf("%s: truncated frame %d", s.f.Name(), s.seq)
+		}
+		return Frame{}, err
+	}
+
+	img, err := NewI420(buf, s.w, s.h)
+	if err != nil {
+		return Frame{}, err
+	}
+
+	frame := Frame{
+		Image: img,
+		Timestamp: time.Duration(s.seq) * s.period,
+		Seq: s.seq,
+	}
+	s.seq++
+	return frame, nil
+}
+
+func (s *i420Source) Close() error {
+	return s.f.Close()
+}
+
+type genSource struct {
+	n int
+	period time.Duration
+	gen func(seq int) image.Image
+	seq int
+}
+
+// Calls gen for each of n frames, period apart. If n is negative the stream
+// never ends.
+func NewGeneratorSource(n int, period time.Duration, gen func(seq int) image.Image) FrameSource {
+	return &genSource{ n: n, period: period, gen: gen }
+}
+
+func (s *genSource) Next() (Frame, error) {
+	if s.n >= 0 && s.seq >= s.n {
+		return Frame{}, io.EOF
+	}
+
+	frame := Frame{
+		Image: s.gen(s.seq),
+		Timestamp: time.Duration(s.seq) * s.period,
+		Seq: s.seq,
+	}
+	s.seq++
+	return frame, nil
+}
+
+func (s *genSource) Close() error {
+	return nil
+}
@@ -0,0 +1,134 @@
+package cv
+
+import (
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDirSourceSkipsBadFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	img := image.NewGray(image.Rect(0, 0, 4, 4))
+	for _, name := range []string{ "a.png", "c.png" } {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		png.Encode(f, img)
+		f.Close()
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "b.png"), []byte("not a png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := NewDirSource(dir, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	if f, err := src.Next(); err != nil || f.Seq != 0 {
+		t.Fatalf("first frame: seq %d, err %v", f.Seq, err)
+	}
+	if _, err := src.Next(); err == nil || err == io.EOF {
+		t.Fatalf("expected a decode error, got %v", err)
+	}
+	f, err := src.Next()
+	if err != nil || f.Seq != 2 || f.Timestamp != 2 * time.Second {
+		t.Fatalf("third frame: seq %d, timestamp %v, err %v", f.Seq, f.Timestamp, err)
+	}
+	if _, err := src.Next(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
+
+func writeTestPNG(t *testing.T, path string, mtime time.Time) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(f, image.NewGray(image.Rect(0, 0, 4, 4)))
+	f.Close()
+
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Timestamps are from the oldest file, whichever name it has
+func TestDirSourceModTimes(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	offsets := map[string]time.Duration{
+		"a.png": 2 * time.Second,
+		"b.png": 0,
+		"c.png": 5 * time.Second,
+	}
+	for name, off := range offsets {
+		writeTestPNG(t, filepath.Join(dir, name), base.Add(off))
+	}
+	// Not a frame, so it doesn't count even though it's older
+	if err := ioutil.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(filepath.Join(dir, "notes.txt"), base.Add(-time.Hour), base.Add(-time.Hour))
+
+	src, err := NewDirSource(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	for i, name := range []string{ "a.png", "b.png", "c.png" } {
+		f, err := src.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Seq != i || f.Timestamp != offsets[name] {
+			t.Errorf("%s: seq %d, timestamp %v, want %d, %v", name, f.Seq, f.Timestamp, i, offsets[name])
+		}
+	}
+}
+
+// Unreadable frames only matter if they're labelled
+func TestDatasetLoadSkipsBadFrames(t *testing.T) {
+	dir := t.TempDir()
+	frames := filepath.Join(dir, "frames")
+	if err := os.Mkdir(frames, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestPNG(t, filepath.Join(frames, "0.png"), time.Now())
+	if err := ioutil.WriteFile(filepath.Join(frames, "1.png"), []byte("not a png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeTestPNG(t, filepath.Join(frames, "2.png"), time.Now())
+
+	ds := &Dataset{
+		Source: "frames",
+		Frames: []FrameLabel{ { Seq: 2 }, { Seq: 0 } },
+		dir: dir,
+	}
+	got, err := ds.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Image == nil || got[1].Image == nil || got[0].Name != "frames#2" {
+		t.Errorf("loaded %+v", got)
+	}
+
+	ds.Frames = append(ds.Frames, FrameLabel{ Seq: 1 })
+	if _, err := ds.Load(); err == nil {
+		t.Errorf("expected an error for the unreadable frame")
+	} else if !strings.Contains(err.Error(), "1.png") {
+		t.Errorf("error %q doesn't say which file was bad", err)
+	}
+}
@@ -0,0 +1,312 @@
+package cv
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+type ThresholdMode int
+
+const (
+	// Everything at or above Level
+	FixedThreshold ThresholdMode = iota
+	// Level picked per image by Otsu's method
+	OtsuThreshold
+	// Everything at least Offset above the mean of its Window x Window
+	// neighbourhood
+	MeanThreshold
+	// As MeanThreshold, but with a Gaussian weighted mean
+	GaussianThreshold
+	// Everything at or above Level, plus anything at or above Low which is
+	// connected to it
+	HysteresisThreshold
+)
+
+var thresholdModeNames = []string{
+	FixedThreshold: "fixed",
+	OtsuThreshold: "otsu",
+	MeanThreshold: "mean",
+	GaussianThreshold: "gaussian",
+	HysteresisThreshold: "hysteresis",
+}
+
+func (m ThresholdMode) String() string {
+	if int(m) < len(thresholdModeNames) {
+		return thresholdModeNames[m]
+	}
+	return fmt.Sprintf("ThresholdMode(%d)", int(m))
+}
+
+func (m ThresholdMode) MarshalText() ([]byte, error) {
+	if int(m) >= len(thresholdModeNames) {
+		return nil, fmt.Errorf("unknown threshold mode %d", int(m))
+	}
+	return []byte(m.String()), nil
+}
+
+func (m *ThresholdMode) UnmarshalText(text []byte) error {
+	for i, name := range thresholdModeNames {
+		if name == string(text) {
+			*m = ThresholdMode(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown threshold mode %q", string(text))
+}
+
+type ThresholdOptions struct {
+	Mode ThresholdMode `json:"mode"`
+	// Fixed level, or the high level for hysteresis
+	Level uint8 `json:"level"`
+	// Low level for hysteresis
+	Low uint8 `json:"low"`
+	// Neighbourhood size and offset for the adaptive modes
+	Window int `json:"window"`
+	Offset int `json:"offset"`
+}
+
+// Checks that o makes sense for its mode. The adaptive modes need a window
+// with a centre pixel.
+func (o ThresholdOptions) Validate() error {
+	if int(o.Mode) < 0 || int(o.Mode) >= len(thresholdModeNames) {
+		return fmt.Errorf("unknown threshold mode %d", int(o.Mode))
+	}
+
+	switch o.Mode {
+	case MeanThreshold, GaussianThreshold:
+		if o.Window < 3 || o.Window % 2 == 0 {
+			return fmt.Errorf("window must be odd and at least 3, got %d", o.Window)
+		}
+		if o.Offset < 0 || o.Offset > 255 {
+			return fmt.Errorf("offset must be between 0 and 255, got %d", o.Offset)
+		}
+	case HysteresisThreshold:
+		if o.Low > o.Level {
+			return fmt.Errorf("low (%d) must not be above level (%d)", o.Low, o.Level)
+		}
+	}
+
+	return nil
+}
+
+// Thresholds img in-place according to o
+func (o ThresholdOptions) Apply(img *image.Gray) {
+	switch o.Mode {
+	case OtsuThreshold:
+		ThresholdOtsu(img)
+	case MeanThreshold:
+		ThresholdAdaptiveMean(img, o.Window, o.Offset)
+	case GaussianThreshold:
+		ThresholdAdaptiveGaussian(img, o.Window, o.Offset)
+	case HysteresisThreshold:
+		ThresholdHysteresis(img, o.Low, o.Level)
+	default:
+		Threshold(img, o.Level)
+	}
+}
+
+// Otsu's method: the level which best splits img's histogram into two
+// classes. Pixels above (not at) the returned level are the upper class. A
+// flat image is all in the lower class.
+func OtsuLevel(img *image.Gray) uint8 {
+	w, h := ImageDims(img)
+
+	var hist [256]int
+	for y := 0; y < h; y++ {
+		row := img.Pix[y * img.Stride : y * img.Stride + w]
+		for _, v := range row {
+			hist[v]++
+		}
+	}
+
+	total := w * h
+	sum := 0
+	for i, n := range hist {
+		sum += i * n
+	}
+
+	var level uint8
+	best := -1.0
+	sumB, wB := 0, 0
+	for i, n := range hist {
+		wB += n
+		if wB == 0 {
+			continue
+		}
+		wF := total - wB
+		if wF == 0 {
+			if best < 0 {
+				// Only one value, so there's nothing to split. Put
+				// it all in the lower class.
+				level = uint8(i)
+			}
+			break
+		}
+		sumB += i * n
+
+		mB := float64(sumB) / float64(wB)
+		mF := float64(sum - sumB) / float64(wF)
+		between := float64(wB) * float64(wF) * (mB - mF) * (mB - mF)
+		if between > best {
+			best = between
+			level = uint8(i)
+		}
+	}
+
+	return level
+}
+
+// Thresholds img at the level chosen by OtsuLevel, which is returned
+func ThresholdOtsu(img *image.Gray) uint8 {
+	level := OtsuLevel(img)
+	if level == 255 {
+		Threshold(img, 255)
+	} else {
+		Threshold(img, level + 1)
+	}
+	return level
+}
+
+// Sets pixels to 255 if they're at least offset above the corresponding
+// value in local, and 0 otherwise
+func thresholdLocal(img *image.Gray, local []float64, offset int) {
+	w, h := ImageDims(img)
+
+	for y := 0; y < h; y++ {
+		row := img.Pix[y * img.Stride : y * img.Stride + w]
+		for x := 0; x < w; x++ {
+			if float64(row[x]) >= local[y * w + x] + float64(offset) {
+				row[x] = 255
+			} else {
+				row[x] = 0
+			}
+		}
+	}
+}
+
+// Thresholds each pixel against the mean of the window x window box around
+// it, clipped to the image. Flat areas come out as 0 as long as offset is
+// positive.
+func ThresholdAdaptiveMean(img *image.Gray, window, offset int) {
+	w, h := ImageDims(img)
+	r := max(1, window / 2)
+
+	// Summed area table, with an extra row and column of zeroes
+	sat := make([]int, (w + 1) * (h + 1))
+	for y := 0; y < h; y++ {
+		rowSum := 0
+		for x := 0; x < w; x++ {
+			rowSum += int(img.Pix[y * img.Stride + x])
+			sat[(y + 1) * (w + 1) + x + 1] = sat[y * (w + 1) + x + 1] + rowSum
+		}
+	}
+
+	local := make([]float64, w * h)
+	for y := 0; y < h; y++ {
+		y0, y1 := max(0, y - r), min(h, y + r + 1)
+		for x := 0; x < w; x++ {
+			x0, x1 := max(0, x - r), min(w, x + r + 1)
+			sum := sat[y1 * (w + 1) + x1] - sat[y0 * (w + 1) + x1] -
+				sat[y1 * (w + 1) + x0] + sat[y0 * (w + 1) + x0]
+			local[y * w + x] = float64(sum) / float64((x1 - x0) * (y1 - y0))
+		}
+	}
+
+	thresholdLocal(img, local, offset)
+}
+
+func gaussianKernel(window int) []float64 {
+	r := max(1, window / 2)
+	// Same relationship between size and sigma as OpenCV
+	sigma := 0.3 * (float64(r) - 1) + 0.8
+
+	k := make([]float64, 2 * r + 1)
+	for i := range k {
+		d := float64(i - r)
+		k[i] = math.Exp(-(d * d) / (2 * sigma * sigma))
+	}
+	return k
+}
+
+// As ThresholdAdaptiveMean, but the mean is weighted by a Gaussian
+func ThresholdAdaptiveGaussian(img *image.Gray, window, offset int) {
+	w, h := ImageDims(img)
+	k := gaussianKernel(window)
+	r := len(k) / 2
+
+	// Separable, renormalising the kernel where it hangs off the edge
+	tmp := make([]float64, w * h)
+	for y := 0; y < h; y++ {
+		row := img.Pix[y * img.Stride : y * img.Stride + w]
+		for x := 0; x < w; x++ {
+			sum, wsum := 0.0, 0.0
+			for i := max(0, x - r); i < min(w, x + r + 1); i++ {
+				sum += k[i - x + r] * float64(row[i])
+				wsum += k[i - x + r]
+			}
+			tmp[y * w + x] = sum / wsum
+		}
+	}
+
+	local := make([]float64, w * h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sum, wsum := 0.0, 0.0
+			for j := max(0, y - r); j < min(h, y + r + 1); j++ {
+				sum += k[j - y + r] * tmp[j * w + x]
+				wsum += k[j - y + r]
+			}
+			local[y * w + x] = sum / wsum
+		}
+	}
+
+	thresholdLocal(img, local, offset)
+}
+
+// Double thresholding: pixels at or above high are kept, along with any at or
+// above low which are (8-)connected to them.
+func ThresholdHysteresis(img *image.Gray, low, high uint8) {
+	w, h := ImageDims(img)
+
+	// 0: off, 1: weak, 2: strong
+	state := make([]uint8, w * h)
+	stack := []int{}
+	for y := 0; y < h; y++ {
+		row := img.Pix[y * img.Stride : y * img.Stride + w]
+		for x, v := range row {
+			if v >= high {
+				state[y * w + x] = 2
+				stack = append(stack, y * w + x)
+			} else if v >= low {
+				state[y * w + x] = 1
+			}
+		}
+	}
+
+	for len(stack) > 0 {
+		idx := stack[len(stack) - 1]
+		stack = stack[:len(stack) - 1]
+		x, y := idx % w, idx / w
+
+		for j := max(0, y - 1); j < min(h, y + 2); j++ {
+			for i := max(0, x - 1); i < min(w, x + 2); i++ {
+				if state[j * w + i] == 1 {
+					state[j * w + i] = 2
+					stack = append(stack, j * w + i)
+				}
+			}
+		}
+	}
+
+	for y := 0; y < h; y++ {
+		row := img.Pix[y * img.Stride : y * img.Stride + w]
+		for x := range row {
+			if state[y * w + x] == 2 {
+				row[x] = 255
+			} else {
+				row[x] = 0
+			}
+		}
+	}
+}
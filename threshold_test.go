@@ -0,0 +1,194 @@
+package cv
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+func TestThresholdOptionsValidate(t *testing.T) {
+	cases := []struct {
+		o ThresholdOptions
+		ok bool
+	}{
+		{ ThresholdOptions{ Mode: FixedThreshold }, true },
+		{ ThresholdOptions{ Mode: OtsuThreshold }, true },
+		{ ThresholdOptions{ Mode: MeanThreshold, Window: 15, Offset: 16 }, true },
+		{ ThresholdOptions{ Mode: GaussianThreshold, Window: 3 }, true },
+		{ ThresholdOptions{ Mode: MeanThreshold, Window: 0, Offset: 16 }, false },
+		{ ThresholdOptions{ Mode: MeanThreshold, Window: 1, Offset: 16 }, false },
+		{ ThresholdOptions{ Mode: GaussianThreshold, Window: 14, Offset: 16 }, false },
+		{ ThresholdOptions{ Mode: MeanThreshold, Window: -3, Offset: 16 }, false },
+		{ ThresholdOptions{ Mode: MeanThreshold, Window: 15, Offset: -1 }, false },
+		{ ThresholdOptions{ Mode: GaussianThreshold, Window: 15, Offset: 256 }, false },
+		{ ThresholdOptions{ Mode: HysteresisThreshold, Low: 64, Level: 128 }, true },
+		{ ThresholdOptions{ Mode: HysteresisThreshold, Low: 128, Level: 128 }, true },
+		{ ThresholdOptions{ Mode: HysteresisThreshold, Low: 129, Level: 128 }, false },
+		// Only the adaptive modes use the window
+		{ ThresholdOptions{ Mode: FixedThreshold, Window: 0 }, true },
+		{ ThresholdOptions{ Mode: ThresholdMode(len(thresholdModeNames)) }, false },
+	}
+
+	for _, c := range cases {
+		if err := c.o.Validate(); (err == nil) != c.ok {
+			t.Errorf("%+v: got error %v, want ok %v", c.o, err, c.ok)
+		}
+	}
+
+	opts := DefaultOptions()
+	opts.LineThreshold = ThresholdOptions{ Mode: MeanThreshold, Window: 8 }
+	if err := opts.Validate(); err == nil {
+		t.Errorf("Options.Validate accepted an even line threshold window")
+	}
+}
+
+// Pixels are 255 where want says so, and 0 everywhere else
+func checkMask(t *testing.T, name string, img *image.Gray, want func(x, y int) bool) {
+	t.Helper()
+	b := img.Bounds()
+	bad := 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			exp := uint8(0)
+			if want(x, y) {
+				exp = 255
+			}
+			if got := img.GrayAt(x, y).Y; got != exp {
+				if bad++; bad <= 10 {
+					t.Errorf("%s: (%d, %d) = %d, want %d", name, x, y, got, exp)
+				}
+			}
+		}
+	}
+}
+
+// Two noisy classes, 60 +/- 15 and 190 +/- 15, in a checkerboard of 8x8
+// blocks. Uneven sizes to make sure the split doesn't just fall in the middle.
+func bimodalGray(rnd *rand.Rand) (*image.Gray, func(x, y int) bool) {
+	img := image.NewGray(image.Rect(0, 0, 96, 64))
+	upper := func(x, y int) bool { return (x / 8 + y / 8) % 3 == 0 }
+
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 96; x++ {
+			v := 60
+			if upper(x, y) {
+				v = 190
+			}
+			img.SetGray(x, y, grayOf(v + rnd.Intn(31) - 15))
+		}
+	}
+	return img, upper
+}
+
+func TestThresholdOtsu(t *testing.T) {
+	img, upper := bimodalGray(rand.New(rand.NewSource(1)))
+
+	level := OtsuLevel(img)
+	if level < 75 || level >= 175 {
+		t.Errorf("Otsu level %d, should be between the classes", level)
+	}
+
+	if got := ThresholdOtsu(img); got != level {
+		t.Errorf("ThresholdOtsu returned %d, OtsuLevel %d", got, level)
+	}
+	checkMask(t, "otsu", img, upper)
+
+	// Through the options, on part of an image
+	img, upper = bimodalGray(rand.New(rand.NewSource(2)))
+	sub := img.SubImage(image.Rect(5, 3, 70, 50)).(*image.Gray)
+	ThresholdOptions{ Mode: OtsuThreshold }.Apply(sub)
+	checkMask(t, "otsu sub-image", sub, upper)
+
+	// A flat image has nothing above the level
+	flat := image.NewGray(image.Rect(0, 0, 10, 10))
+	for i := range flat.Pix {
+		flat.Pix[i] = 100
+	}
+	ThresholdOtsu(flat)
+	checkMask(t, "otsu flat", flat, func(x, y int) bool { return false })
+}
+
+func grayOf(v int) color.Gray {
+	return color.Gray{ uint8(min(255, max(0, v))) }
+}
+
+// Isolated spots 40 brighter than a steep left-to-right ramp. A fixed level
+// can't pick out the spots, but they're always well above their
+// neighbourhood.
+func gradientSpots() (*image.Gray, func(x, y int) bool) {
+	w, h := 200, 60
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	spot := func(x, y int) bool { return x % 9 == 4 && y % 9 == 4 }
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := 40 + x * 4 / 5
+			if spot(x, y) {
+				v += 40
+			}
+			img.SetGray(x, y, grayOf(v))
+		}
+	}
+	return img, spot
+}
+
+func TestThresholdAdaptive(t *testing.T) {
+	for _, mode := range []ThresholdMode{ MeanThreshold, GaussianThreshold } {
+		for _, window := range []int{ 3, 7, 15 } {
+			img, spot := gradientSpots()
+			o := ThresholdOptions{ Mode: mode, Window: window, Offset: 20 }
+			o.Apply(img)
+			checkMask(t, mode.String(), img, spot)
+		}
+	}
+
+	// A fixed level splits the ramp, rather than finding the spots
+	img, spot := gradientSpots()
+	ThresholdOptions{ Mode: FixedThreshold, Level: 128 }.Apply(img)
+	if img.GrayAt(4, 4).Y != 0 || img.GrayAt(190, 0).Y != 255 || !spot(4, 4) {
+		t.Errorf("fixed threshold unexpectedly found the spots")
+	}
+}
+
+func TestThresholdHysteresis(t *testing.T) {
+	rows := []string{
+		"..........",
+		".Hww..w...",
+		"...w...ww.",
+		"....w.....",
+		"..........",
+		"......Hw..",
+		"ww.......w",
+		".........H",
+	}
+	// H is strong, w is weak. Weak pixels are kept if they're connected,
+	// diagonally or not, to a strong one.
+	keep := []string{
+		"..........",
+		".###......",
+		"...#......",
+		"....#.....",
+		"..........",
+		"......##..",
+		".........#",
+		".........#",
+	}
+
+	img := image.NewGray(image.Rect(0, 0, 10, len(rows)))
+	for y, row := range rows {
+		for x, c := range row {
+			v := 10
+			switch c {
+			case 'H':
+				v = 200
+			case 'w':
+				v = 100
+			}
+			img.SetGray(x, y, grayOf(v))
+		}
+	}
+
+	ThresholdOptions{ Mode: HysteresisThreshold, Low: 64, Level: 128 }.Apply(img)
+	checkMask(t, "hysteresis", img, func(x, y int) bool { return keep[y][x] == '#' })
+}
@@ -0,0 +1,70 @@
+package cv
+
+import (
+	"fmt"
+	"image"
+	"io"
+)
+
+// Receives the intermediate results of a detector as it runs, to be logged,
+// visualised or thrown away. name says which stage the value came from, e.g.
+// "board.col_delta.threshold", and value is typically an *image.Gray (edge
+// images and line projections), []Tuple (blob lists), []uint8 (averages) or
+// a single number or Tuple.
+//
+// Images are copies, so may be kept. Trace is called synchronously from the
+// detector, so a Tracer shared between concurrent calls must do its own
+// locking.
+type Tracer interface {
+	Trace(name string, value interface{})
+}
+
+type TracerFunc func(name string, value interface{})
+
+func (f TracerFunc) Trace(name string, value interface{}) {
+	f(name, value)
+}
+
+// Prints everything to W, one stage per line. Line projections are printed
+// in full, but larger images only have their size printed.
+type PrintTracer struct {
+	W io.Writer
+}
+
+func (p PrintTracer) Trace(name string, value interface{}) {
+	if img, ok := value.(*image.Gray); ok {
+		w, h := ImageDims(img)
+		if w != 1 && h != 1 {
+			fmt.Fprintf(p.W, "%s: %dx%d image\n", name, w, h)
+			return
+		}
+		value = img.Pix
+	}
+
+	fmt.Fprintln(p.W, name + ":", value)
+}
+
+func (o *Options) trace(name string, value interface{}) {
+	if o.Tracer == nil {
+		return
+	}
+
+	// The detectors modify their images in-place, so the tracer needs its
+	// own copy
+	if img, ok := value.(*image.Gray); ok {
+		cp := *img
+		cp.Pix = append([]uint8(nil), img.Pix...)
+		value = &cp
+	}
+
+	o.Tracer.Trace(name, value)
+}
+
+// Passes everything on to each of its tracers in turn
+type MultiTracer []Tracer
+
+func (m MultiTracer) Trace(name string, value interface{}) {
+	for _, t := range m {
+		t.Trace(name, value)
+	}
+}
@@ -0,0 +1,274 @@
+package cv
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A range of values for one option, named by its JSON path in Options, e.g.
+// "line_stripes" or "edge_threshold.level". Either Values is set, or the
+// range is Min to Max (inclusive) in steps of Step.
+type TuneParam struct {
+	Name string `json:"name"`
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+	// For random search, Step may be 0 to sample anywhere in the range.
+	// This only works for floating point options.
+	Step float64 `json:"step"`
+	Values []interface{} `json:"values,omitempty"`
+}
+
+type TuneSpec struct {
+	Params []TuneParam `json:"params"`
+	// "grid" tries every combination, "random" tries Samples random ones
+	Search string `json:"search"`
+	Samples int `json:"samples"`
+	Seed int64 `json:"seed"`
+}
+
+type TuneResult struct {
+	Params map[string]interface{} `json:"params"`
+	Options *Options `json:"-"`
+	// Lower is better, see EvalScore
+	Score float64 `json:"score"`
+	Report *EvalReport `json:"report"`
+}
+
+func LoadTuneSpec(path string) (*TuneSpec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &TuneSpec{ Search: "grid" }
+	if err := json.Unmarshal(data, spec); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	if err := spec.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return spec, nil
+}
+
+func (s *TuneSpec) Validate() error {
+	if len(s.Params) == 0 {
+		return fmt.Errorf("no params to tune")
+	}
+
+	for _, p := range s.Params {
+		if len(p.Values) > 0 {
+			continue
+		}
+		if p.Max < p.Min {
+			return fmt.Errorf("%s: max is less than min", p.Name)
+		}
+		if s.Search == "grid" && p.Step <= 0 {
+			return fmt.Errorf("%s: grid search needs a step or values", p.Name)
+		}
+	}
+
+	switch s.Search {
+	case "grid":
+	case "random":
+		if s.Samples < 1 {
+			return fmt.Errorf("random search needs samples")
+		}
+	default:
+		return fmt.Errorf("unknown search %q, want grid or random", s.Search)
+	}
+
+	return nil
+}
+
+// Every value in the range, for grid search
+func (p TuneParam) values() []interface{} {
+	if len(p.Values) > 0 {
+		return p.Values
+	}
+
+	vals := []interface{}{}
+	// Allow for rounding error on the last step
+	for i := 0; ; i++ {
+		v := p.Min + float64(i) * p.Step
+		if v > p.Max + p.Step * 1e-6 {
+			break
+		}
+		vals = append(vals, v)
+	}
+	return vals
+}
+
+func (p TuneParam) random(rnd *rand.Rand) interface{} {
+	if len(p.Values) > 0 {
+		return p.Values[rnd.Intn(len(p.Values))]
+	}
+	if p.Step <= 0 {
+		return p.Min + rnd.Float64() * (p.Max - p.Min)
+	}
+	n := int(math.Floor((p.Max - p.Min) / p.Step + 1e-6)) + 1
+	return p.Min + float64(rnd.Intn(n)) * p.Step
+}
+
+func (s *TuneSpec) candidates() []map[string]interface{} {
+	cands := []map[string]interface{}{}
+
+	if s.Search == "random" {
+		rnd := rand.New(rand.NewSource(s.Seed))
+		for i := 0; i < s.Samples; i++ {
+			c := map[string]interface{}{}
+			for _, p := range s.Params {
+				c[p.Name] = p.random(rnd)
+			}
+			cands = append(cands, c)
+		}
+		return cands
+	}
+
+	var walk func(i int, c map[string]interface{})
+	walk = func(i int, c map[string]interface{}) {
+		if i == len(s.Params) {
+			cp := map[string]interface{}{}
+			for k, v := range c {
+				cp[k] = v
+			}
+			cands = append(cands, cp)
+			return
+		}
+
+		p := s.Params[i]
+		for _, v := range p.values() {
+			c[p.Name] = v
+			walk(i + 1, c)
+		}
+	}
+	walk(0, map[string]interface{}{})
+
+	return cands
+}
+
+// Returns a copy of base with params set, by their JSON names
+func applyParams(base *Options, params map[string]interface{}) (*Options, error) {
+	data, err := json.Marshal(base)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree map[string]interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+
+	for name, v := range params {
+		path := strings.Split(name, ".")
+		node := tree
+		for _, key := range path[:len(path) - 1] {
+			next, ok := node[key].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("unknown option %q", name)
+			}
+			node = next
+		}
+
+		last := path[len(path) - 1]
+		if _, ok := node[last]; !ok {
+			return nil, fmt.Errorf("unknown option %q", name)
+		}
+		node[last] = v
+	}
+
+	data, err = json.Marshal(tree)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &Options{}
+	if err := json.Unmarshal(data, opts); err != nil {
+		return nil, err
+	}
+
+	return opts, opts.Validate()
+}
+
+// A single number summarising a report, lower is better. Each miss rate,
+// false positive rate and mean error (which are normalised to the frame size)
+// counts equally.
+func EvalScore(r *EvalReport) float64 {
+	score := r.FalsePositiveRate
+	if r.BoardTP + r.BoardFN > 0 {
+		score += 1 - r.DetectionRate
+	}
+	if r.ColorLabelled > 0 {
+		score += 1 - float64(r.ColorCorrect) / float64(r.ColorLabelled)
+	}
+	if n := r.Horizon.N + r.HorizonLine.N + r.HorizonMissed; n > 0 {
+		score += float64(r.HorizonMissed) / float64(n)
+	}
+
+	for _, s := range []ErrorStats{ r.Left, r.Right, r.Bottom, r.Horizon, r.HorizonLine } {
+		score += s.Mean
+	}
+
+	return score
+}
+
+// Evaluates base with each combination of parameters from spec, using
+// workers goroutines (or one per CPU if workers is 0). Results are returned
+// best first. Combinations which give invalid options are an error, so that
+// typos in the spec are caught before a long run.
+func Tune(frames []LabelledFrame, palette Palette, base *Options, spec *TuneSpec, workers int) ([]TuneResult, error) {
+	base = base.orDefault()
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+
+	cands := spec.candidates()
+	results := make([]TuneResult, len(cands))
+	for i, c := range cands {
+		opts, err := applyParams(base, c)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", c, err)
+		}
+		// Tracing from many goroutines wouldn't be much use
+		opts.Tracer = nil
+		results[i] = TuneResult{ Params: c, Options: opts }
+	}
+
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	var wg sync.WaitGroup
+	work := make(chan int)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range work {
+				r := &results[idx]
+				r.Report = Evaluate(frames, palette, r.Options, 0)
+				r.Score = EvalScore(r.Report)
+			}
+		}()
+	}
+
+	for i := range results {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score < results[j].Score
+	})
+
+	return results, nil
+}
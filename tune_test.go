@@ -0,0 +1,250 @@
+package cv
+
+import (
+	"reflect"
+	"testing"
+)
+
+// A small labelled dataset of rendered scenes: each palette colour in a few
+// places, with noise and a gradient, and a couple of frames with no board
+func sceneDataset() []LabelledFrame {
+	pal := DefaultPalette()
+	frames := []LabelledFrame{}
+
+	add := func(name string, s *Scene, color string) {
+		img, truth := s.Render()
+		horizon := truth.Horizon
+		l := FrameLabel{ Horizon: &horizon }
+		if len(truth.Boards) == 0 {
+			l.NoBoard = true
+		} else {
+			bt := truth.Boards[0]
+			bottom := bt.Bottom
+			l.Board = &BoardLabel{ Left: bt.Left, Right: bt.Right, Bottom: &bottom, Color: color }
+		}
+		frames = append(frames, LabelledFrame{ Image: img, Label: l, Name: name })
+	}
+
+	for i, pc := range pal {
+		s := DefaultScene()
+		s.Noise, s.Gradient, s.Seed = 3, 0.1, int64(i)
+		s.Boards[0].Color = pc.Color
+		s.Boards[0].Left = 0.1 + 0.15 * float64(i)
+		s.Boards[0].Right = s.Boards[0].Left + 0.3
+		s.Boards[0].Bottom = 0.6 + 0.05 * float64(i)
+		add(pc.Name, s, pc.Name)
+	}
+
+	for i := 0; i < 2; i++ {
+		s := DefaultScene()
+		s.Boards = nil
+		s.Noise, s.Seed = 3, int64(10 + i)
+		s.Horizon = 0.7 + 0.1 * float64(i)
+		add("empty", s, "")
+	}
+
+	return frames
+}
+
+func TestApplyParams(t *testing.T) {
+	base := DefaultOptions()
+	base.Metric = ChromaMetric
+
+	opts, err := applyParams(base, map[string]interface{}{
+		"edge_threshold.level": 100.0,
+		"edge_threshold.mode": "otsu",
+		"line_stripes": 16.0,
+		"metric": "ycbcr",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := *base
+	want.EdgeThreshold.Level = 100
+	want.EdgeThreshold.Mode = OtsuThreshold
+	want.LineStripes = 16
+	want.Metric = YCbCrMetric
+	if !reflect.DeepEqual(opts, &want) {
+		t.Errorf("got %+v, want %+v", opts, &want)
+	}
+
+	// base is left alone
+	if base.EdgeThreshold.Level != 128 || base.LineStripes != 32 || base.Metric != ChromaMetric {
+		t.Errorf("base was modified: %+v", base)
+	}
+
+	// Anything else comes from base
+	opts, err = applyParams(base, map[string]interface{}{ "horizon_iterations": 50.0 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Metric != ChromaMetric || opts.HorizonIterations != 50 {
+		t.Errorf("metric %v, iterations %d", opts.Metric.Name(), opts.HorizonIterations)
+	}
+
+	for _, bad := range []map[string]interface{}{
+		{ "nope": 1.0 },
+		{ "edge_threshold.nope": 1.0 },
+		{ "line_stripes.level": 1.0 },
+		{ "metric": "nope" },
+		// Valid JSON, but not valid options
+		{ "line_stripes": 0.0 },
+		{ "edge_threshold.mode": "mean", "edge_threshold.window": 4.0 },
+	} {
+		if _, err := applyParams(base, bad); err == nil {
+			t.Errorf("%v: expected an error", bad)
+		}
+	}
+}
+
+func TestTuneCandidates(t *testing.T) {
+	spec := &TuneSpec{
+		Search: "grid",
+		Params: []TuneParam{
+			{ Name: "a", Min: 0, Max: 0.3, Step: 0.1 },
+			{ Name: "b", Values: []interface{}{ "x", "y" } },
+		},
+	}
+	cands := spec.candidates()
+	if len(cands) != 8 {
+		t.Fatalf("%d grid candidates, want 8", len(cands))
+	}
+	seen := map[[2]interface{}]bool{}
+	for _, c := range cands {
+		seen[[2]interface{}{ c["a"], c["b"] }] = true
+	}
+	if len(seen) != 8 {
+		t.Errorf("grid candidates aren't all different: %v", cands)
+	}
+
+	spec = &TuneSpec{
+		Search: "random",
+		Samples: 20,
+		Seed: 7,
+		Params: []TuneParam{
+			{ Name: "a", Min: 10, Max: 20, Step: 5 },
+			{ Name: "b", Min: 0, Max: 1 },
+		},
+	}
+	cands = spec.candidates()
+	if !reflect.DeepEqual(cands, spec.candidates()) {
+		t.Errorf("random candidates differ with the same seed")
+	}
+	for _, c := range cands {
+		if a := c["a"].(float64); a != 10 && a != 15 && a != 20 {
+			t.Errorf("a = %v, not on a step", a)
+		}
+		if b := c["b"].(float64); b < 0 || b > 1 {
+			t.Errorf("b = %v, out of range", b)
+		}
+	}
+}
+
+// Tuning over a grid which includes the base options can't do any worse than
+// them, gives the same answer however many workers there are, and is
+// deterministic for a given random seed
+func TestTuneScenes(t *testing.T) {
+	frames := sceneDataset()
+	pal := DefaultPalette()
+	base := DefaultOptions()
+	baseScore := EvalScore(Evaluate(frames, pal, base, 0))
+
+	spec := &TuneSpec{
+		Search: "grid",
+		Params: []TuneParam{
+			{ Name: "edge_threshold.level", Values: []interface{}{ 16.0, 64.0, 128.0, 250.0 } },
+			{ Name: "board_color_threshold", Values: []interface{}{ 8.0, 48.0 } },
+		},
+	}
+
+	results, err := Tune(frames, pal, base, spec, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 8 {
+		t.Fatalf("%d results, want 8", len(results))
+	}
+
+	for i, r := range results {
+		if i > 0 && r.Score < results[i - 1].Score {
+			t.Errorf("results aren't sorted: %v before %v", results[i - 1].Score, r.Score)
+		}
+		if got := EvalScore(r.Report); got != r.Score {
+			t.Errorf("%v: score %v doesn't match its report's %v", r.Params, r.Score, got)
+		}
+	}
+	// The candidate matching the base options should score the same as them
+	found := false
+	for _, r := range results {
+		if r.Params["edge_threshold.level"] == 128.0 && r.Params["board_color_threshold"] == 48.0 {
+			found = true
+			if r.Score != baseScore {
+				t.Errorf("base options scored %v through Tune, %v directly", r.Score, baseScore)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("no result for the base options")
+	}
+
+	if results[0].Score > baseScore {
+		t.Errorf("best score %v is worse than the base options' %v", results[0].Score, baseScore)
+	}
+	// The extremes should be noticeably worse
+	if last := results[len(results) - 1]; last.Score <= baseScore {
+		t.Errorf("worst candidate %v scored %v, no worse than the base options' %v", last.Params, last.Score, baseScore)
+	}
+
+	parallel, err := Tune(frames, pal, base, spec, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range results {
+		if !reflect.DeepEqual(parallel[i].Params, results[i].Params) || parallel[i].Score != results[i].Score {
+			t.Errorf("result %d with 4 workers: %v %v, with 1: %v %v", i,
+				parallel[i].Params, parallel[i].Score, results[i].Params, results[i].Score)
+		}
+	}
+
+	spec = &TuneSpec{
+		Search: "random",
+		Samples: 6,
+		Seed: 1,
+		Params: []TuneParam{
+			{ Name: "edge_threshold.level", Min: 64, Max: 192, Step: 16 },
+		},
+	}
+	a, err := Tune(frames, pal, base, spec, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Tune(frames, pal, base, spec, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range a {
+		if !reflect.DeepEqual(a[i].Params, b[i].Params) || a[i].Score != b[i].Score {
+			t.Errorf("random result %d differs between runs", i)
+		}
+	}
+}
+
+func TestTuneBadSpec(t *testing.T) {
+	frames := sceneDataset()[:1]
+
+	for _, spec := range []*TuneSpec{
+		{ Search: "grid" },
+		{ Search: "grid", Params: []TuneParam{ { Name: "line_stripes", Min: 1, Max: 4 } } },
+		{ Search: "random", Params: []TuneParam{ { Name: "line_stripes", Min: 1, Max: 4 } } },
+		{ Search: "annealing", Params: []TuneParam{ { Name: "line_stripes", Values: []interface{}{ 1.0 } } } },
+		{ Search: "grid", Params: []TuneParam{ { Name: "line_stripes", Min: 4, Max: 1, Step: 1 } } },
+		// A typo, or an invalid value, should be caught before any work
+		{ Search: "grid", Params: []TuneParam{ { Name: "line_stripe", Values: []interface{}{ 1.0 } } } },
+		{ Search: "grid", Params: []TuneParam{ { Name: "line_stripes", Values: []interface{}{ 0.0, 1.0 } } } },
+	} {
+		if _, err := Tune(frames, DefaultPalette(), nil, spec, 1); err == nil {
+			t.Errorf("%+v: expected an error", spec)
+		}
+	}
+}
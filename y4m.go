@@ -0,0 +1,236 @@
+package cv
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// YUV4MPEG2 streams, for recording raw frames and replaying them bit-exactly.
+// Only the 4:2:0, 4:2:2, 4:4:4, 4:1:1 and 4:4:0 colourspaces are supported,
+// there's no tag for 4:1:0.
+//
+// The 4:2:0 variants only differ in where the chroma samples are sited, which
+// makes no difference to how the planes are stored, so they're all read the
+// same way. The tag is kept so that it can be written back out unchanged.
+
+const y4mMagic = "YUV4MPEG2"
+
+// What a stream without a C tag is
+const y4mDefaultColorspace = "420jpeg"
+
+var y4mColorspaces = map[string]image.YCbCrSubsampleRatio{
+	"420": image.YCbCrSubsampleRatio420,
+	"420jpeg": image.YCbCrSubsampleRatio420,
+	"420paldv": image.YCbCrSubsampleRatio420,
+	"420mpeg2": image.YCbCrSubsampleRatio420,
+	"422": image.YCbCrSubsampleRatio422,
+	"444": image.YCbCrSubsampleRatio444,
+	"411": image.YCbCrSubsampleRatio411,
+	"440": image.YCbCrSubsampleRatio440,
+}
+
+func y4mColorspace(ratio image.YCbCrSubsampleRatio) (string, error) {
+	switch ratio {
+	case image.YCbCrSubsampleRatio420:
+		return "420jpeg", nil
+	case image.YCbCrSubsampleRatio422:
+		return "422", nil
+	case image.YCbCrSubsampleRatio444:
+		return "444", nil
+	case image.YCbCrSubsampleRatio411:
+		return "411", nil
+	case image.YCbCrSubsampleRatio440:
+		return "440", nil
+	}
+	return "", fmt.Errorf("y4m: unsupported subsample ratio %v", ratio)
+}
+
+// Sizes of each chroma plane for a width x height frame
+func chromaDims(width, height int, ratio image.YCbCrSubsampleRatio) (cw, ch int) {
+	hsub, vsub := SubsampleFactors(ratio)
+	return (width + hsub - 1) / hsub, (height + vsub - 1) / vsub
+}
+
+type Y4MReader struct {
+	r *bufio.Reader
+
+	Width, Height int
+	SubsampleRatio image.YCbCrSubsampleRatio
+	// The stream's C tag, e.g. "420mpeg2", for passing on to
+	// NewY4MWriterColorspace
+	Colorspace string
+	// Frames per second is FrameRateNum / FrameRateDen. Both 0 if the
+	// stream didn't say
+	FrameRateNum, FrameRateDen int
+}
+
+// Reads the stream header from r, ready for ReadFrame
+func NewY4MReader(r io.Reader) (*Y4MReader, error) {
+	yr := &Y4MReader{
+		r: bufio.NewReader(r),
+		SubsampleRatio: image.YCbCrSubsampleRatio420,
+		Colorspace: y4mDefaultColorspace,
+	}
+
+	line, err := yr.r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("y4m: reading header: %v", err)
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != y4mMagic {
+		return nil, fmt.Errorf("y4m: not a YUV4MPEG2 stream")
+	}
+
+	for _, f := range fields[1:] {
+		val := f[1:]
+		switch f[0] {
+		case 'W':
+			yr.Width, err = strconv.Atoi(val)
+		case 'H':
+			yr.Height, err = strconv.Atoi(val)
+		case 'F':
+			parts := strings.SplitN(val, ":", 2)
+			if len(parts) != 2 {
+				err = fmt.Errorf("bad frame rate %q", val)
+				break
+			}
+			yr.FrameRateNum, err = strconv.Atoi(parts[0])
+			if err == nil {
+				yr.FrameRateDen, err = strconv.Atoi(parts[1])
+			}
+		case 'C':
+			ratio, ok := y4mColorspaces[val]
+			if !ok {
+				err = fmt.Errorf("unsupported colourspace %q", val)
+				break
+			}
+			yr.SubsampleRatio = ratio
+			yr.Colorspace = val
+		}
+		// Interlacing, aspect ratio and extensions don't matter here
+
+		if err != nil {
+			return nil, fmt.Errorf("y4m: %v", err)
+		}
+	}
+
+	if yr.Width <= 0 || yr.Height <= 0 {
+		return nil, fmt.Errorf("y4m: bad frame size %dx%d", yr.Width, yr.Height)
+	}
+
+	return yr, nil
+}
+
+// Returns the next frame, or io.EOF once there are no more
+func (yr *Y4MReader) ReadFrame() (*image.YCbCr, error) {
+	line, err := yr.r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && len(line) == 0 {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("y4m: reading frame header: %v", err)
+	}
+	if !strings.HasPrefix(line, "FRAME") {
+		return nil, fmt.Errorf("y4m: bad frame header %q", strings.TrimSpace(line))
+	}
+
+	img := image.NewYCbCr(image.Rect(0, 0, yr.Width, yr.Height), yr.SubsampleRatio)
+
+	// NewYCbCr packs the planes with Stride == width, so they can be read
+	// straight in
+	for _, plane := range [][]uint8{ img.Y, img.Cb, img.Cr } {
+		if _, err := io.ReadFull(yr.r, plane); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, fmt.Errorf("y4m: reading frame: %v", err)
+		}
+	}
+
+	return img, nil
+}
+
+type Y4MWriter struct {
+	w *bufio.Writer
+
+	width, height int
+	ratio image.YCbCrSubsampleRatio
+}
+
+// Writes the stream header to w. Every frame must then be width x height with
+// the given subsample ratio. fpsNum / fpsDen is the frame rate. 4:2:0 is
+// written as 420jpeg.
+func NewY4MWriter(w io.Writer, width, height int, ratio image.YCbCrSubsampleRatio, fpsNum, fpsDen int) (*Y4MWriter, error) {
+	cs, err := y4mColorspace(ratio)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewY4MWriterColorspace(w, width, height, cs, fpsNum, fpsDen)
+}
+
+// As NewY4MWriter, but with the C tag given directly, e.g. from
+// Y4MReader.Colorspace. The subsample ratio comes from the tag.
+func NewY4MWriterColorspace(w io.Writer, width, height int, colorspace string, fpsNum, fpsDen int) (*Y4MWriter, error) {
+	ratio, ok := y4mColorspaces[colorspace]
+	if !ok {
+		return nil, fmt.Errorf("y4m: unsupported colourspace %q", colorspace)
+	}
+
+	yw := &Y4MWriter{
+		w: bufio.NewWriter(w),
+		width: width,
+		height: height,
+		ratio: ratio,
+	}
+
+	_, err := fmt.Fprintf(yw.w, "%s W%d H%d F%d:%d Ip A1:1 C%s\n", y4mMagic, width, height, fpsNum, fpsDen, colorspace)
+	if err != nil {
+		return nil, err
+	}
+
+	return yw, nil
+}
+
+func writePlane(w io.Writer, pix []uint8, start, stride, width, height int) error {
+	for y := 0; y < height; y++ {
+		row := pix[start + y * stride : start + y * stride + width]
+		if _, err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (yw *Y4MWriter) WriteFrame(img *image.YCbCr) error {
+	w, h := ImageDims(img)
+	if w != yw.width || h != yw.height || img.SubsampleRatio != yw.ratio {
+		return fmt.Errorf("y4m: frame is %dx%d %v, stream is %dx%d %v",
+			w, h, img.SubsampleRatio, yw.width, yw.height, yw.ratio)
+	}
+
+	if _, err := io.WriteString(yw.w, "FRAME\n"); err != nil {
+		return err
+	}
+
+	min := img.Rect.Min
+	if err := writePlane(yw.w, img.Y, img.YOffset(min.X, min.Y), img.YStride, w, h); err != nil {
+		return err
+	}
+
+	cw, ch := chromaDims(w, h, img.SubsampleRatio)
+	cstart := img.COffset(min.X, min.Y)
+	if err := writePlane(yw.w, img.Cb, cstart, img.CStride, cw, ch); err != nil {
+		return err
+	}
+	if err := writePlane(yw.w, img.Cr, cstart, img.CStride, cw, ch); err != nil {
+		return err
+	}
+
+	return yw.w.Flush()
+}
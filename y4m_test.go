@@ -0,0 +1,148 @@
+package cv
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func checkSameYCbCr(t *testing.T, name string, got, want *image.YCbCr) {
+	t.Helper()
+	if got.SubsampleRatio != want.SubsampleRatio {
+		t.Fatalf("%s: ratio %v, want %v", name, got.SubsampleRatio, want.SubsampleRatio)
+	}
+	gw, gh := ImageDims(got)
+	ww, wh := ImageDims(want)
+	if gw != ww || gh != wh {
+		t.Fatalf("%s: %dx%d, want %dx%d", name, gw, gh, ww, wh)
+	}
+
+	gb, wb := got.Bounds(), want.Bounds()
+	for y := 0; y < wh; y++ {
+		for x := 0; x < ww; x++ {
+			g, w := got.YCbCrAt(gb.Min.X + x, gb.Min.Y + y), want.YCbCrAt(wb.Min.X + x, wb.Min.Y + y)
+			if g != w {
+				t.Fatalf("%s: (%d, %d) is %v, want %v", name, x, y, g, w)
+			}
+		}
+	}
+}
+
+// Every supported C tag should come back as it went in, along with the
+// frames. Odd sizes make sure the chroma planes are rounded up, and one of the
+// frames is a sub-image so the strides don't match the width.
+func TestY4MRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	tags := make([]string, 0, len(y4mColorspaces))
+	for tag := range y4mColorspaces {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	for _, tag := range tags {
+		ratio := y4mColorspaces[tag]
+		w, h := 13, 7
+
+		big := noisyYCbCr(rnd, image.Rect(0, 0, w + 8, h + 8), ratio)
+		frames := []*image.YCbCr{
+			noisyYCbCr(rnd, image.Rect(0, 0, w, h), ratio),
+			big.SubImage(image.Rect(4, 4, 4 + w, 4 + h)).(*image.YCbCr),
+		}
+
+		var buf bytes.Buffer
+		yw, err := NewY4MWriterColorspace(&buf, w, h, tag, 30000, 1001)
+		if err != nil {
+			t.Fatalf("%s: %v", tag, err)
+		}
+		for _, f := range frames {
+			if err := yw.WriteFrame(f); err != nil {
+				t.Fatalf("%s: %v", tag, err)
+			}
+		}
+		stream := append([]byte(nil), buf.Bytes()...)
+
+		yr, err := NewY4MReader(bytes.NewReader(stream))
+		if err != nil {
+			t.Fatalf("%s: %v", tag, err)
+		}
+		if yr.Colorspace != tag || yr.SubsampleRatio != ratio {
+			t.Errorf("%s: read as %q %v", tag, yr.Colorspace, yr.SubsampleRatio)
+		}
+		if yr.Width != w || yr.Height != h || yr.FrameRateNum != 30000 || yr.FrameRateDen != 1001 {
+			t.Errorf("%s: read as %dx%d at %d/%d", tag, yr.Width, yr.Height, yr.FrameRateNum, yr.FrameRateDen)
+		}
+
+		// Copy the stream using what the reader found, which should give
+		// exactly the same bytes
+		var out bytes.Buffer
+		cw, err := NewY4MWriterColorspace(&out, yr.Width, yr.Height, yr.Colorspace, yr.FrameRateNum, yr.FrameRateDen)
+		if err != nil {
+			t.Fatalf("%s: %v", tag, err)
+		}
+		for i, want := range frames {
+			got, err := yr.ReadFrame()
+			if err != nil {
+				t.Fatalf("%s: frame %d: %v", tag, i, err)
+			}
+			checkSameYCbCr(t, tag, got, want)
+			if err := cw.WriteFrame(got); err != nil {
+				t.Fatalf("%s: %v", tag, err)
+			}
+		}
+		if _, err := yr.ReadFrame(); err != io.EOF {
+			t.Errorf("%s: got %v after the last frame, want EOF", tag, err)
+		}
+
+		if !bytes.Equal(out.Bytes(), stream) {
+			t.Errorf("%s: copied stream differs", tag)
+		}
+	}
+}
+
+func TestY4MColorspaceDefaults(t *testing.T) {
+	yr, err := NewY4MReader(strings.NewReader("YUV4MPEG2 W4 H2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if yr.Colorspace != "420jpeg" || yr.SubsampleRatio != image.YCbCrSubsampleRatio420 {
+		t.Errorf("no C tag read as %q %v, want 420jpeg", yr.Colorspace, yr.SubsampleRatio)
+	}
+
+	var buf bytes.Buffer
+	yw, err := NewY4MWriter(&buf, 4, 2, image.YCbCrSubsampleRatio420, 25, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := yw.WriteFrame(image.NewYCbCr(image.Rect(0, 0, 4, 2), image.YCbCrSubsampleRatio420)); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := buf.ReadString('\n'); !strings.HasSuffix(got, " C420jpeg\n") {
+		t.Errorf("4:2:0 header %q, want C420jpeg", got)
+	}
+}
+
+func TestY4MUnsupported(t *testing.T) {
+	if _, err := NewY4MReader(strings.NewReader("YUV4MPEG2 W4 H2 Cmono\n")); err == nil {
+		t.Errorf("read a mono stream")
+	}
+	if _, err := NewY4MWriterColorspace(&bytes.Buffer{}, 4, 2, "mono", 25, 1); err == nil {
+		t.Errorf("wrote a mono stream")
+	}
+	if _, err := NewY4MWriter(&bytes.Buffer{}, 4, 2, image.YCbCrSubsampleRatio410, 25, 1); err == nil {
+		t.Errorf("wrote a 4:1:0 stream")
+	}
+
+	// Frames have to match the stream
+	yw, err := NewY4MWriterColorspace(&bytes.Buffer{}, 4, 2, "420mpeg2", 25, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := yw.WriteFrame(image.NewYCbCr(image.Rect(0, 0, 4, 2), image.YCbCrSubsampleRatio422)); err == nil {
+		t.Errorf("wrote a 4:2:2 frame to a 4:2:0 stream")
+	}
+}